@@ -0,0 +1,97 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"sync"
+
+	interfaces "github.com/loopholelabs/scale-signature-interfaces"
+)
+
+// Result is the result of running the function chain of an Instance with a single signature
+type Result[T interfaces.Signature] struct {
+	// Signature is the signature that was passed in, which contains the output of the chain if Err is nil
+	Signature T
+
+	// Err is the error returned by the chain, if any
+	Err error
+}
+
+// RunAsync runs the function chain in the background, and returns a channel
+// that receives a single Result once the chain completes before being closed
+//
+// Cancelling the given context only interrupts the modules running this call, which are then
+// discarded and replaced. Like Run, concurrent calls run at the same time if every function in the
+// chain is stateless, and are otherwise queued and run one after the other (see Instance.Run).
+func (i *Instance[T]) RunAsync(ctx context.Context, signature T) <-chan Result[T] {
+	results := make(chan Result[T], 1)
+	go func() {
+		defer close(results)
+		results <- Result[T]{Signature: signature, Err: i.Run(ctx, signature)}
+	}()
+	return results
+}
+
+// RunMany runs the function chain once for every given signature, and returns the results in the same order
+//
+// If every function in the chain is stateless, the signatures are run concurrently using modules
+// from the module pools, otherwise they are run one after the other. Cancelling the given context
+// interrupts all the calls that have not completed yet.
+func (i *Instance[T]) RunMany(ctx context.Context, signatures []T) []Result[T] {
+	results := make([]Result[T], len(signatures))
+	if i.exclusive() {
+		for index, signature := range signatures {
+			results[index] = Result[T]{Signature: signature, Err: i.Run(ctx, signature)}
+		}
+		return results
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(signatures))
+	for index, signature := range signatures {
+		go func(index int, signature T) {
+			defer wg.Done()
+			results[index] = Result[T]{Signature: signature, Err: i.Run(ctx, signature)}
+		}(index, signature)
+	}
+	wg.Wait()
+	return results
+}
+
+// stateless returns true if none of the functions of the instance have a stateful module
+func (i *Instance[T]) stateless() bool {
+	for fn := i.head; fn != nil; fn = fn.next {
+		if !fn.stateless() {
+			return false
+		}
+	}
+	return true
+}
+
+// stateless returns true if the function, and all of its branches if it is a fan-out, use module pools
+func (f *function[T]) stateless() bool {
+	if f.module != nil {
+		return false
+	}
+	for _, branch := range f.branches {
+		if !branch.stateless() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSignatures(inputs ...string) []*testSignature {
+	signatures := make([]*testSignature, 0, len(inputs))
+	for _, input := range inputs {
+		sig := newTestSignature()
+		sig.data = []byte(input)
+		signatures = append(signatures, sig)
+	}
+	return signatures
+}
+
+func TestRunAsync(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithFunction(testGuest(t, "second", true)))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	looping := instance.RunAsync(ctx, newTestSignatures("l")[0])
+
+	// The looping call must not block other calls on the same instance
+	result := <-instance.RunAsync(context.Background(), newTestSignatures("echo")[0])
+	require.NoError(t, result.Err)
+	assert.Equal(t, "echo", string(result.Signature.data))
+
+	select {
+	case <-looping:
+		t.Fatal("looping call completed before it was cancelled")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+	result = <-looping
+	assert.ErrorIs(t, result.Err, context.Canceled)
+	_, ok := <-looping
+	assert.False(t, ok)
+
+	output, err := runTestInstance(t, instance, "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "echo", output)
+
+	require.NoError(t, r.Close(context.Background()))
+}
+
+func TestRunMany(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithFunction(testGuest(t, "second", true)))
+
+	instance, err := r.Instance(func(sig *testSignature) (*testSignature, error) {
+		sig.data = append(sig.data, '!')
+		return sig, nil
+	})
+	require.NoError(t, err)
+
+	results := instance.RunMany(context.Background(), newTestSignatures("a", "nb", "t", "nd"))
+	require.Len(t, results, 4)
+	require.NoError(t, results[0].Err)
+	assert.Equal(t, "a", string(results[0].Signature.data))
+	require.NoError(t, results[1].Err)
+	assert.Equal(t, "nb!", string(results[1].Signature.data))
+	assert.Error(t, results[2].Err)
+	require.NoError(t, results[3].Err)
+	assert.Equal(t, "nd!", string(results[3].Signature.data))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	results = instance.RunMany(ctx, newTestSignatures("l", "l"))
+	for _, result := range results {
+		assert.ErrorIs(t, result.Err, context.DeadlineExceeded)
+	}
+
+	require.NoError(t, r.Close(context.Background()))
+}
+
+func TestRunManyStateful(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	// Stateful functions run the signatures one after the other
+	results := instance.RunMany(context.Background(), newTestSignatures("c-", "c-", "c-"))
+	for i, result := range results {
+		require.NoError(t, result.Err)
+		assert.Equal(t, []byte{'c', byte(i + 1)}, result.Signature.data)
+	}
+
+	require.NoError(t, r.Close(context.Background()))
+}
@@ -18,12 +18,20 @@ package scale
 
 import (
 	"context"
+	"crypto/ed25519"
 	"errors"
 	"io"
+	"log/slog"
 	"regexp"
+	"time"
 
 	extension "github.com/loopholelabs/scale-extension-interfaces"
 	interfaces "github.com/loopholelabs/scale-signature-interfaces"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/sys"
+
 	"github.com/loopholelabs/scale/scalefunc"
 )
 
@@ -32,26 +40,227 @@ var (
 	ErrNoFunctions     = errors.New("no functions provided")
 	ErrInvalidFunction = errors.New("invalid function")
 	ErrInvalidEnv      = errors.New("invalid environment variable")
+
+	ErrInvalidExecutionLimit  = errors.New("invalid execution limit")
+	ErrExecutionLimitExceeded = errors.New("execution limit exceeded")
+
+	ErrInvalidMemoryLimit    = errors.New("invalid memory limit")
+	ErrMemoryLimitExceeded   = errors.New("memory limit exceeded")
+	ErrSignatureSizeExceeded = errors.New("signature size limit exceeded")
+
+	ErrInvalidPoolConfig = errors.New("invalid module pool configuration")
+	ErrInvalidClock      = errors.New("invalid clock configuration")
+
+	ErrInvalidFanOut = errors.New("invalid fan-out")
 )
 
 var (
 	envStringRegex = regexp.MustCompile(`[^A-Za-z0-9_]`)
 )
 
-type configFunction struct {
+type configFunction[T interfaces.Signature] struct {
 	function *scalefunc.V1BetaSchema
-	env      map[string]string
+	config   *FunctionConfig
+
+	// branches and merge are only set for fan-outs, in which case function and config are nil
+	branches []configFunction[T]
+	merge    Merge[T]
+}
+
+// FunctionConfig is the per-function configuration for a Scale Function
+type FunctionConfig struct {
+	env              map[string]string
+	maxMemoryPages   uint32
+	maxSignatureSize uint32
+
+	maxModules       uint32
+	minIdleModules   uint32
+	blockOnExhausted bool
+	idleTimeout      time.Duration
+
+	recycleAfterErrors uint32
+
+	args       []string
+	fsConfig   wazero.FSConfig
+	stdout     io.Writer
+	stderr     io.Writer
+	randSource io.Reader
+
+	walltime           sys.Walltime
+	walltimeResolution sys.ClockResolution
+	nanotime           sys.Nanotime
+	nanotimeResolution sys.ClockResolution
+}
+
+// NewFunctionConfig returns a new, empty FunctionConfig
+func NewFunctionConfig() *FunctionConfig {
+	return new(FunctionConfig)
+}
+
+// WithEnv sets the environment variables for the function
+func (f *FunctionConfig) WithEnv(env map[string]string) *FunctionConfig {
+	f.env = env
+	return f
+}
+
+// WithMaxMemoryPages sets the maximum number of 64KiB pages the
+// function's linear memory is allowed to grow to. A value of 0 means
+// the limit defined by the function itself is used. Functions that import
+// their memory instead of defining it cannot be limited, and fail to load.
+func (f *FunctionConfig) WithMaxMemoryPages(pages uint32) *FunctionConfig {
+	f.maxMemoryPages = pages
+	return f
+}
+
+// WithMaxModules bounds the number of modules (both idle and in use) that the module pool
+// of a stateless function is allowed to create. A value of 0 means the pool is unbounded.
+//
+// When all the modules are in use, retrieving a module either fails with ErrPoolExhausted or
+// blocks until a module becomes available, depending on WithBlockOnExhausted.
+func (f *FunctionConfig) WithMaxModules(modules uint32) *FunctionConfig {
+	f.maxModules = modules
+	return f
+}
+
+// WithMinIdleModules sets the number of idle modules that a bounded module pool will
+// pre-warm on startup and try to maintain afterwards.
+func (f *FunctionConfig) WithMinIdleModules(modules uint32) *FunctionConfig {
+	f.minIdleModules = modules
+	return f
+}
+
+// WithBlockOnExhausted makes retrieving a module from an exhausted bounded pool wait until
+// a module is returned or the caller's context is done, instead of failing immediately.
+func (f *FunctionConfig) WithBlockOnExhausted(block bool) *FunctionConfig {
+	f.blockOnExhausted = block
+	return f
+}
+
+// WithIdleTimeout closes modules that have been idle in a bounded module pool
+// for longer than the given timeout, while keeping at least the minimum number of idle modules.
+func (f *FunctionConfig) WithIdleTimeout(timeout time.Duration) *FunctionConfig {
+	f.idleTimeout = timeout
+	return f
+}
+
+// WithRecycleAfterErrors discards a module of the function after the given number of consecutive
+// failed runs, and uses a freshly instantiated module for the next run. A value of 0 means modules
+// are only recycled after they trap.
+func (f *FunctionConfig) WithRecycleAfterErrors(count uint32) *FunctionConfig {
+	f.recycleAfterErrors = count
+	return f
+}
+
+// WithArgs sets the command-line arguments that are visible to the function through WASI,
+// where the first argument is conventionally the name of the program
+func (f *FunctionConfig) WithArgs(args ...string) *FunctionConfig {
+	f.args = args
+	return f
+}
+
+// WithFSConfig mounts the directories or file systems in the given wazero.FSConfig into the function,
+// which can use wazero.FSConfig.WithReadOnlyDirMount for read-only mounts. By default, no file system is mounted.
+func (f *FunctionConfig) WithFSConfig(config wazero.FSConfig) *FunctionConfig {
+	f.fsConfig = config
+	return f
+}
+
+// WithStdout sets the writer that the function's stdout is written to, instead of the one in the Config
+func (f *FunctionConfig) WithStdout(w io.Writer) *FunctionConfig {
+	f.stdout = w
+	return f
+}
+
+// WithStderr sets the writer that the function's stderr is written to, instead of the one in the Config
+func (f *FunctionConfig) WithStderr(w io.Writer) *FunctionConfig {
+	f.stderr = w
+	return f
+}
+
+// WithRandSource sets the source of the random numbers the function reads through WASI,
+// which defaults to crypto/rand.Reader. A deterministic source is useful for testing.
+func (f *FunctionConfig) WithRandSource(source io.Reader) *FunctionConfig {
+	f.randSource = source
+	return f
+}
+
+// WithWalltime sets the clock the function reads the wall time from,
+// which defaults to the system clock. A fake clock is useful for testing.
+func (f *FunctionConfig) WithWalltime(walltime sys.Walltime, resolution sys.ClockResolution) *FunctionConfig {
+	f.walltime = walltime
+	f.walltimeResolution = resolution
+	return f
+}
+
+// WithNanotime sets the monotonic clock the function reads the time from,
+// which defaults to the system clock. A fake clock is useful for testing.
+func (f *FunctionConfig) WithNanotime(nanotime sys.Nanotime, resolution sys.ClockResolution) *FunctionConfig {
+	f.nanotime = nanotime
+	f.nanotimeResolution = resolution
+	return f
+}
+
+// WithMaxSignatureSize sets the maximum size (in bytes) of an encoded signature
+// that will be written into the function's memory via its `resize` export.
+// A value of 0 means no limit.
+func (f *FunctionConfig) WithMaxSignatureSize(size uint32) *FunctionConfig {
+	f.maxSignatureSize = size
+	return f
+}
+
+// moduleConfig applies the function's environment variables, arguments, file systems,
+// random source and clocks to the given module configuration
+func (f *FunctionConfig) moduleConfig(config wazero.ModuleConfig) wazero.ModuleConfig {
+	for k, v := range f.env {
+		config = config.WithEnv(k, v)
+	}
+	if len(f.args) > 0 {
+		config = config.WithArgs(f.args...)
+	}
+	if f.fsConfig != nil {
+		config = config.WithFSConfig(f.fsConfig)
+	}
+	if f.randSource != nil {
+		config = config.WithRandSource(f.randSource)
+	}
+	if f.walltime != nil {
+		config = config.WithWalltime(f.walltime, f.walltimeResolution)
+	}
+	if f.nanotime != nil {
+		config = config.WithNanotime(f.nanotime, f.nanotimeResolution)
+	}
+	return config
 }
 
 // Config is the configuration for a Scale Runtime
 type Config[T interfaces.Signature] struct {
 	newSignature interfaces.New[T]
-	functions    []configFunction
+	functions    []configFunction[T]
 	context      context.Context
 	stdout       io.Writer
 	stderr       io.Writer
 	rawOutput    bool
 	extensions   []extension.Extension
+
+	executionLimit time.Duration
+
+	metrics Metrics
+
+	tracerProvider trace.TracerProvider
+
+	compilationCacheDir string
+
+	router Router[T]
+
+	recycleHandler func(event RecycleEvent)
+
+	warmInstances uint32
+
+	snapshots bool
+
+	logHandler slog.Handler
+
+	trustedKeys []ed25519.PublicKey
 }
 
 // NewConfig returns a new Scale Runtime Config
@@ -73,17 +282,64 @@ func (c *Config[T]) validate() error {
 		c.context = context.Background()
 	}
 
-	for _, f := range c.functions {
-		if f.function == nil {
-			return ErrInvalidFunction
+	if c.metrics == nil {
+		c.metrics = noopMetrics{}
+	}
+
+	if c.executionLimit < 0 {
+		return ErrInvalidExecutionLimit
+	}
+
+	for _, key := range c.trustedKeys {
+		if len(key) != ed25519.PublicKeySize {
+			return scalefunc.ErrInvalidKey
 		}
-		for k := range f.env {
-			if !validEnv(k) {
-				return ErrInvalidEnv
+	}
+
+	for _, f := range c.functions {
+		if f.function == nil && f.branches != nil {
+			if len(f.branches) == 0 || f.merge == nil {
+				return ErrInvalidFanOut
+			}
+			for _, b := range f.branches {
+				err := b.validate()
+				if err != nil {
+					return err
+				}
 			}
+			continue
 		}
+		err := f.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f configFunction[T]) validate() error {
+	if f.function == nil {
+		return ErrInvalidFunction
 	}
+	return f.config.validate()
+}
 
+func (f *FunctionConfig) validate() error {
+	for k := range f.env {
+		if !validEnv(k) {
+			return ErrInvalidEnv
+		}
+	}
+	if f.maxMemoryPages > maxMemoryPages {
+		return ErrInvalidMemoryLimit
+	}
+	if f.minIdleModules > f.maxModules || f.idleTimeout < 0 || (f.maxModules == 0 && f.idleTimeout > 0) {
+		return ErrInvalidPoolConfig
+	}
+	if (f.walltime != nil && f.walltimeResolution == 0) || (f.nanotime != nil && f.nanotimeResolution == 0) {
+		return ErrInvalidClock
+	}
 	return nil
 }
 
@@ -98,15 +354,55 @@ func (c *Config[T]) WithSignature(newSignature interfaces.New[T]) *Config[T] {
 }
 
 func (c *Config[T]) WithFunction(function *scalefunc.V1BetaSchema, env ...map[string]string) *Config[T] {
-	f := configFunction{
+	config := NewFunctionConfig()
+	if len(env) > 0 {
+		config.WithEnv(env[0])
+	}
+
+	return c.WithFunctionConfig(function, config)
+}
+
+// WithFunctionConfig adds a function to the chain along with its own FunctionConfig
+func (c *Config[T]) WithFunctionConfig(function *scalefunc.V1BetaSchema, config *FunctionConfig) *Config[T] {
+	if config == nil {
+		config = NewFunctionConfig()
+	}
+
+	c.functions = append(c.functions, configFunction[T]{
 		function: function,
+		config:   config,
+	})
+	return c
+}
+
+// WithFanOut adds a fan-out to the chain, which runs all the given functions concurrently
+// (each with its own copy of the signature) and combines their results using merge.
+//
+// The merged signature is passed on to the next function in the chain, and the functions
+// in a fan-out return their signature unchanged when they call `next`.
+func (c *Config[T]) WithFanOut(merge Merge[T], functions ...*scalefunc.V1BetaSchema) *Config[T] {
+	return c.WithFanOutConfig(merge, nil, functions...)
+}
+
+// WithFanOutConfig adds a fan-out to the chain like WithFanOut, with every function in the
+// fan-out using the given FunctionConfig
+func (c *Config[T]) WithFanOutConfig(merge Merge[T], config *FunctionConfig, functions ...*scalefunc.V1BetaSchema) *Config[T] {
+	if config == nil {
+		config = NewFunctionConfig()
 	}
 
-	if len(env) > 0 {
-		f.env = env[0]
+	branches := make([]configFunction[T], 0, len(functions))
+	for _, function := range functions {
+		branches = append(branches, configFunction[T]{
+			function: function,
+			config:   config,
+		})
 	}
 
-	c.functions = append(c.functions, f)
+	c.functions = append(c.functions, configFunction[T]{
+		branches: branches,
+		merge:    merge,
+	})
 	return c
 }
 
@@ -132,11 +428,101 @@ func (c *Config[T]) WithStderr(w io.Writer) *Config[T] {
 	return c
 }
 
+// WithLogHandler emits the output of the functions as structured log records through the given handler,
+// instead of writing it to the writers set with WithStdout and WithStderr. See log.Logger for details.
+func (c *Config[T]) WithLogHandler(handler slog.Handler) *Config[T] {
+	c.logHandler = handler
+	return c
+}
+
 func (c *Config[T]) WithRawOutput(rawOutput bool) *Config[T] {
 	c.rawOutput = rawOutput
 	return c
 }
 
+// WithExecutionLimit sets the maximum amount of wall-clock time a single function
+// is allowed to spend inside its `run` export (including any downstream functions
+// it calls via `next`). A limit of 0 disables the check.
+//
+// When a function exceeds the limit only that call is aborted and an error wrapping
+// ErrExecutionLimitExceeded is returned, the rest of the chain remains usable.
+func (c *Config[T]) WithExecutionLimit(limit time.Duration) *Config[T] {
+	c.executionLimit = limit
+	return c
+}
+
+// WithMetrics sets the Metrics implementation that will receive runtime metrics
+func (c *Config[T]) WithMetrics(metrics Metrics) *Config[T] {
+	c.metrics = metrics
+	return c
+}
+
+// WithTracerProvider sets the OpenTelemetry TracerProvider used by the runtime
+//
+// When set, every call to a function in the chain is recorded as a span (parented under
+// the span in the context passed to Instance.Run), and the trace data sent by guests
+// is decoded and re-emitted as spans under the span of the function that sent it.
+func (c *Config[T]) WithTracerProvider(tracerProvider trace.TracerProvider) *Config[T] {
+	c.tracerProvider = tracerProvider
+	return c
+}
+
+// WithCompilationCache persists the compiled functions in the given directory, so that
+// subsequent runtimes (including ones in other processes) can skip compiling functions that
+// were already compiled.
+//
+// Cache entries are keyed by the content of the function's wasm binary (and therefore its hash), the
+// Scale Runtime version, and the version of the underlying wasm runtime. Corrupted entries are discarded
+// and the affected functions are recompiled.
+func (c *Config[T]) WithCompilationCache(dir string) *Config[T] {
+	c.compilationCacheDir = dir
+	return c
+}
+
+// WithRouter sets a Router that picks which function runs whenever a function calls `next`,
+// which allows the functions of a single Scale Runtime to form a DAG instead of a linear chain.
+// A Router that returns RouteEnd skips the rest of the chain.
+//
+// Routes must not form cycles, as a function cannot be re-entered while it is running. A route to a function
+// that is already running in the same call fails with ErrRouteCycle.
+func (c *Config[T]) WithRouter(router Router[T]) *Config[T] {
+	c.router = router
+	return c
+}
+
+// WithRecycleHandler sets a function that is called with a RecycleEvent every time a module
+// is discarded because it trapped or failed too many times in a row (see FunctionConfig.WithRecycleAfterErrors)
+//
+// The handler is called on the execution path, and should not block.
+func (c *Config[T]) WithRecycleHandler(handler func(event RecycleEvent)) *Config[T] {
+	c.recycleHandler = handler
+	return c
+}
+
+// WithWarmInstances keeps up to the given number of fully initialized instances ready
+// to be retrieved with Scale.AcquireInstance, and refills them in the background.
+// A value of 0 means instances are always created when they are acquired.
+func (c *Config[T]) WithWarmInstances(instances uint32) *Config[T] {
+	c.warmInstances = instances
+	return c
+}
+
+// WithSnapshots allows the state of stateful functions to be saved using Instance.Snapshot and
+// restored using Scale.RestoreInstance. This requires the mutable globals of stateful functions to
+// be exported, so their wasm binaries are rewritten when they are loaded.
+func (c *Config[T]) WithSnapshots(enabled bool) *Config[T] {
+	c.snapshots = enabled
+	return c
+}
+
+// WithTrustedKeys only allows functions that are signed by at least one of the given
+// ed25519 public keys (see scalefunc.Sign), including functions that are added to the chain
+// after the runtime is created. Unsigned or untrusted functions are rejected.
+func (c *Config[T]) WithTrustedKeys(keys ...ed25519.PublicKey) *Config[T] {
+	c.trustedKeys = append(c.trustedKeys, keys...)
+	return c
+}
+
 // validEnv returns true if the string is valid for use as an environment variable
 func validEnv(str string) bool {
 	return !envStringRegex.MatchString(str)
@@ -0,0 +1,110 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	interfaces "github.com/loopholelabs/scale-signature-interfaces"
+)
+
+// Merge combines the signatures returned by the functions in a fan-out into a single signature.
+//
+// The signature that was passed into the fan-out is provided as sig, and results contains
+// the signature returned by each function in the fan-out, in the order the functions were added.
+type Merge[T interfaces.Signature] func(sig T, results []T) (T, error)
+
+// newFanOutTemplate creates a template that runs the given templates concurrently
+//
+// Fan-out templates are not compiled themselves, and are never part of another fan-out
+func newFanOutTemplate[T interfaces.Signature](runtime *Scale[T], branches []*template[T], merge Merge[T]) *template[T] {
+	identifiers := make([]string, 0, len(branches))
+	for _, b := range branches {
+		identifiers = append(identifiers, b.identifier)
+	}
+
+	return &template[T]{
+		runtime:    runtime,
+		identifier: fmt.Sprintf("fanout[%s]", strings.Join(identifiers, ",")),
+		branches:   branches,
+		merge:      merge,
+	}
+}
+
+// flattenTemplates returns the given templates with every fan-out
+// replaced by the templates it contains
+func flattenTemplates[T interfaces.Signature](templates []*template[T]) []*template[T] {
+	flattened := make([]*template[T], 0, len(templates))
+	for _, t := range templates {
+		if t.branches != nil {
+			flattened = append(flattened, t.branches...)
+		} else {
+			flattened = append(flattened, t)
+		}
+	}
+	return flattened
+}
+
+// runFanOut runs every branch of the fan-out concurrently with a copy of the given signature,
+// merges the results, and passes the merged signature on to the next function in the chain
+//
+// The final result is written back into the given signature
+func (f *function[T]) runFanOut(ctx context.Context, signature T) error {
+	buf := signature.Write()
+
+	results := make([]T, len(f.branches))
+	errs := make([]error, len(f.branches))
+	var wg sync.WaitGroup
+	for i, branch := range f.branches {
+		results[i] = f.template.runtime.config.newSignature()
+		err := results[i].Read(buf)
+		if err != nil {
+			return fmt.Errorf("failed to copy signature for function '%s': %w", branch.template.identifier, err)
+		}
+
+		wg.Add(1)
+		go func(i int, branch *function[T]) {
+			defer wg.Done()
+			err := branch.run(ctx, results[i])
+			if err != nil {
+				errs[i] = wrapRunError(branch.template.identifier, err)
+			}
+		}(i, branch)
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err != nil {
+		return err
+	}
+
+	merged, err := f.template.merge(signature, results)
+	if err != nil {
+		return fmt.Errorf("failed to merge signatures for '%s': %w", f.template.identifier, err)
+	}
+
+	merged, err = f.runNext(ctx, merged)
+	if err != nil {
+		return err
+	}
+
+	return signature.Read(merged.Write())
+}
@@ -35,6 +35,12 @@ type function[T interfaces.Signature] struct {
 	// next is the next function in the chain
 	next *function[T]
 
+	// branches are the functions that run concurrently if this function is a fan-out
+	branches []*function[T]
+
+	// fanOut is the fan-out that this function belongs to, if any
+	fanOut *function[T]
+
 	// module is the optional, stateful, instantiated module for this function
 	//
 	// If the function is stateless, then this will be nil
@@ -48,6 +54,21 @@ func newFunction[T interfaces.Signature](ctx context.Context, instance *Instance
 		template: template,
 	}
 
+	if template.branches != nil {
+		fn.branches = make([]*function[T], 0, len(template.branches))
+		for _, b := range template.branches {
+			branch, err := newFunction(ctx, instance, b)
+			if err != nil {
+				// The fan-out is not part of the instance yet, so closing the instance would not close its branches
+				fn.close()
+				return nil, err
+			}
+			branch.fanOut = fn
+			fn.branches = append(fn.branches, branch)
+		}
+		return fn, nil
+	}
+
 	if template.modulePool == nil {
 		fn.module, err = newModule[T](ctx, fn.template)
 		if err != nil {
@@ -59,15 +80,41 @@ func newFunction[T interfaces.Signature](ctx context.Context, instance *Instance
 	return fn, nil
 }
 
-func (f *function[T]) getModule(signature T) (*module[T], error) {
+// run runs the function with the given signature
+func (f *function[T]) run(ctx context.Context, signature T) error {
+	ctx = f.withActive(ctx)
+	if f.branches != nil {
+		return f.runFanOut(ctx, signature)
+	}
+
+	m, err := f.getModule(ctx, signature)
+	if err != nil {
+		return fmt.Errorf("failed to get module for function '%s': %w", f.template.identifier, err)
+	}
+	err = m.run(ctx)
+	m.record(err)
+	f.putModule(m)
+	return err
+}
+
+func (f *function[T]) getModule(ctx context.Context, signature T) (*module[T], error) {
 	if f.module != nil {
+		if f.module.recyclable() {
+			// The stateful module trapped or was aborted during a previous call (for example because
+			// it exceeded the execution limit), so it has to be replaced before it can be used
+			f.recycled(f.module)
+			err := f.replaceModule()
+			if err != nil {
+				return nil, err
+			}
+		}
 		f.module.setSignature(signature)
 		return f.module, nil
 	}
 	if f.template.modulePool == nil {
 		return nil, fmt.Errorf("cannot get module from pool for function %s: module pool is nil", f.template.identifier)
 	}
-	m, err := f.template.modulePool.Get()
+	m, err := f.template.modulePool.Get(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get module from pool for function %s: %w", f.template.identifier, err)
 	}
@@ -78,7 +125,41 @@ func (f *function[T]) getModule(signature T) (*module[T], error) {
 
 func (f *function[T]) putModule(m *module[T]) {
 	if f.template.modulePool != nil {
+		if m.recyclable() {
+			// Closed or poisoned modules are not usable anymore and must not be returned to the pool
+			f.recycled(m)
+			m.cleanup()
+			f.template.modulePool.Discard(m)
+			return
+		}
 		m.cleanup()
 		f.template.modulePool.Put(m)
 	}
 }
+
+// replaceModule replaces the stateful module of this function with a freshly instantiated one
+func (f *function[T]) replaceModule() error {
+	m, err := newModule[T](f.template.runtime.config.context, f.template)
+	if err != nil {
+		return fmt.Errorf("failed to replace module for function '%s': %w", f.template.identifier, err)
+	}
+	// wazero does not close modules that trapped, so the old module is
+	// closed here to release it from the runtime before it is replaced
+	f.module.cleanup()
+	f.module.Close(f.module)
+	f.module = m
+	f.module.register(f)
+	return nil
+}
+
+// close closes the stateful modules of this function and its branches
+func (f *function[T]) close() {
+	for _, branch := range f.branches {
+		branch.close()
+	}
+	if f.module != nil {
+		f.module.cleanup()
+		f.module.Close(f.module)
+		f.module = nil
+	}
+}
@@ -0,0 +1,305 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	wasmImportSectionID = 2
+	wasmGlobalSectionID = 6
+	wasmExportSectionID = 7
+	wasmTagSectionID    = 13
+
+	wasmExternFunction = 0x00
+	wasmExternTable    = 0x01
+	wasmExternMemory   = 0x02
+	wasmExternGlobal   = 0x03
+	wasmExternTag      = 0x04
+
+	wasmValueTypeI32 = 0x7f
+	wasmValueTypeI64 = 0x7e
+	wasmValueTypeF32 = 0x7d
+	wasmValueTypeF64 = 0x7c
+
+	wasmGlobalMutable = 0x01
+
+	// globalExportPrefix is the prefix of the names under which mutable globals are exported
+	globalExportPrefix = "scale_global_"
+)
+
+// wasmSection is the location of a single section within a wasm binary
+type wasmSection struct {
+	id byte
+
+	// start is the offset of the section's id, content is the offset of its contents,
+	// and end is the offset right after its contents
+	start   int
+	content int
+	end     int
+}
+
+// wasmSections returns the locations of all the sections in the given wasm binary
+func wasmSections(wasm []byte) ([]wasmSection, error) {
+	if len(wasm) < wasmHeaderSize {
+		return nil, errInvalidWasmBinary
+	}
+
+	var sections []wasmSection
+	offset := wasmHeaderSize
+	for offset < len(wasm) {
+		section := wasmSection{id: wasm[offset], start: offset}
+		offset++
+		size, n := binary.Uvarint(wasm[offset:])
+		if n <= 0 || uint64(len(wasm)-offset-n) < size {
+			return nil, errInvalidWasmBinary
+		}
+		offset += n
+		section.content = offset
+		offset += int(size)
+		section.end = offset
+		sections = append(sections, section)
+	}
+
+	return sections, nil
+}
+
+// exportMutableGlobals rewrites the given wasm binary so that every mutable numeric global
+// it defines is exported, which allows the globals to be read and restored by snapshots.
+//
+// The names of the added exports are returned in the order of the globals. If the binary
+// does not define any mutable numeric globals, it is returned as is. The original binary is not modified.
+func exportMutableGlobals(wasm []byte) ([]byte, []string, error) {
+	sections, err := wasmSections(wasm)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var importedGlobals uint64
+	var indices []uint64
+	exportSection := -1
+	insertAt := len(wasm)
+	for i, section := range sections {
+		content := wasm[section.content:section.end]
+		switch section.id {
+		case wasmImportSectionID:
+			importedGlobals, err = countImportedGlobals(content)
+		case wasmGlobalSectionID:
+			indices, err = mutableGlobals(content, importedGlobals)
+		case wasmExportSectionID:
+			exportSection = i
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Sections after the export section must come after it in the binary, apart from custom sections
+		if section.id > wasmExportSectionID && section.id != wasmTagSectionID && insertAt == len(wasm) {
+			insertAt = section.start
+		}
+	}
+
+	if len(indices) == 0 {
+		return wasm, nil, nil
+	}
+
+	var count uint64
+	var entries []byte
+	if exportSection >= 0 {
+		section := sections[exportSection]
+		var n int
+		count, n = binary.Uvarint(wasm[section.content:section.end])
+		if n <= 0 {
+			return nil, nil, errInvalidWasmBinary
+		}
+		entries = wasm[section.content+n : section.end]
+	}
+
+	names := make([]string, 0, len(indices))
+	content := binary.AppendUvarint(nil, count+uint64(len(indices)))
+	content = append(content, entries...)
+	for _, index := range indices {
+		name := fmt.Sprintf("%s%d", globalExportPrefix, index)
+		names = append(names, name)
+		content = binary.AppendUvarint(content, uint64(len(name)))
+		content = append(content, name...)
+		content = append(content, wasmExternGlobal)
+		content = binary.AppendUvarint(content, index)
+	}
+
+	start, end := insertAt, insertAt
+	if exportSection >= 0 {
+		start, end = sections[exportSection].start, sections[exportSection].end
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(wasm)+len(content)+binary.MaxVarintLen32+1))
+	out.Write(wasm[:start])
+	out.WriteByte(wasmExportSectionID)
+	out.Write(binary.AppendUvarint(nil, uint64(len(content))))
+	out.Write(content)
+	out.Write(wasm[end:])
+	return out.Bytes(), names, nil
+}
+
+// countImportedGlobals returns the number of globals imported by the given import section,
+// which offset the indices of the globals defined by the module itself
+func countImportedGlobals(section []byte) (uint64, error) {
+	r := &wasmReader{b: section}
+	count := r.uvarint()
+	var globals uint64
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		r.skip(int(r.uvarint())) // module
+		r.skip(int(r.uvarint())) // name
+		switch r.byte() {
+		case wasmExternFunction:
+			r.uvarint()
+		case wasmExternTable:
+			r.byte()
+			r.limits()
+		case wasmExternMemory:
+			r.limits()
+		case wasmExternGlobal:
+			r.byte()
+			r.byte()
+			globals++
+		case wasmExternTag:
+			r.byte()
+			r.uvarint()
+		default:
+			return 0, errInvalidWasmBinary
+		}
+	}
+	return globals, r.done()
+}
+
+// mutableGlobals returns the indices of the mutable numeric globals defined in the given global section
+func mutableGlobals(section []byte, offset uint64) ([]uint64, error) {
+	r := &wasmReader{b: section}
+	count := r.uvarint()
+	var indices []uint64
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		valueType := r.byte()
+		mutability := r.byte()
+		r.constExpr()
+		if mutability != wasmGlobalMutable {
+			continue
+		}
+		switch valueType {
+		case wasmValueTypeI32, wasmValueTypeI64, wasmValueTypeF32, wasmValueTypeF64:
+			indices = append(indices, offset+i)
+		}
+	}
+	return indices, r.done()
+}
+
+// wasmReader reads values from a wasm binary, and records the first error it encounters
+type wasmReader struct {
+	b   []byte
+	off int
+	err error
+}
+
+func (r *wasmReader) fail() {
+	if r.err == nil {
+		r.err = errInvalidWasmBinary
+	}
+	r.off = len(r.b)
+}
+
+func (r *wasmReader) byte() byte {
+	if r.off >= len(r.b) {
+		r.fail()
+		return 0
+	}
+	r.off++
+	return r.b[r.off-1]
+}
+
+func (r *wasmReader) skip(n int) {
+	if n < 0 || n > len(r.b)-r.off {
+		r.fail()
+		return
+	}
+	r.off += n
+}
+
+func (r *wasmReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.off:])
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.off += n
+	return v
+}
+
+// svarint skips a signed LEB128 value, which cannot be decoded using binary.Varint
+func (r *wasmReader) svarint() {
+	for r.err == nil {
+		if r.byte()&0x80 == 0 {
+			return
+		}
+	}
+}
+
+func (r *wasmReader) limits() {
+	flags := r.byte()
+	r.uvarint()
+	if flags&limitsHasMax != 0 {
+		r.uvarint()
+	}
+}
+
+// constExpr skips a constant expression, including the instructions of the extended constant expressions proposal
+func (r *wasmReader) constExpr() {
+	for r.err == nil {
+		switch opcode := r.byte(); opcode {
+		case 0x0b: // end
+			return
+		case 0x41, 0x42: // i32.const, i64.const
+			r.svarint()
+		case 0x43: // f32.const
+			r.skip(4)
+		case 0x44: // f64.const
+			r.skip(8)
+		case 0x23, 0xd2: // global.get, ref.func
+			r.uvarint()
+		case 0xd0: // ref.null
+			r.byte()
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e: // i32.add, i32.sub, i32.mul, i64.add, i64.sub, i64.mul
+		case 0xfd: // v128.const
+			if r.uvarint() != 12 {
+				r.fail()
+				return
+			}
+			r.skip(16)
+		default:
+			r.fail()
+		}
+	}
+}
+
+// done returns the first error encountered by the reader, or an error if it did not read all of its input
+func (r *wasmReader) done() error {
+	if r.err == nil && r.off != len(r.b) {
+		return errInvalidWasmBinary
+	}
+	return r.err
+}
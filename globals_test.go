@@ -0,0 +1,84 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+)
+
+func TestExportMutableGlobals(t *testing.T) {
+	// (type (func)) (func (type 0))
+	prefix := append(append([]byte{}, wasmHeader...), 0x01, 0x04, 0x01, 0x60, 0x00, 0x00, 0x03, 0x02, 0x01, 0x00)
+	// (global i32 (i32.const 0)) (global (mut i64) (i64.const 5))
+	globals := []byte{0x06, 0x0b, 0x02, 0x7f, 0x00, 0x41, 0x00, 0x0b, 0x7e, 0x01, 0x42, 0x05, 0x0b}
+	// (export "f" (func 0))
+	exports := []byte{0x07, 0x05, 0x01, 0x01, 'f', 0x00, 0x00}
+	code := []byte{0x0a, 0x04, 0x01, 0x02, 0x00, 0x0b}
+
+	instantiate := func(t *testing.T, wasm []byte) {
+		ctx := context.Background()
+		runtime := wazero.NewRuntime(ctx)
+		t.Cleanup(func() {
+			_ = runtime.Close(ctx)
+		})
+
+		instantiated, err := runtime.Instantiate(ctx, wasm)
+		require.NoError(t, err)
+		assert.Equal(t, uint64(5), instantiated.ExportedGlobal("scale_global_1").Get())
+	}
+
+	t.Run("ExistingExports", func(t *testing.T) {
+		wasm := append(append(append(append([]byte{}, prefix...), globals...), exports...), code...)
+
+		exported, names, err := exportMutableGlobals(wasm)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"scale_global_1"}, names)
+		instantiate(t, exported)
+	})
+
+	t.Run("NoExports", func(t *testing.T) {
+		wasm := append(append(append([]byte{}, prefix...), globals...), code...)
+
+		exported, names, err := exportMutableGlobals(wasm)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"scale_global_1"}, names)
+		instantiate(t, exported)
+	})
+
+	t.Run("NoMutableGlobals", func(t *testing.T) {
+		wasm := append(append([]byte{}, prefix...), code...)
+
+		exported, names, err := exportMutableGlobals(wasm)
+		require.NoError(t, err)
+		assert.Empty(t, names)
+		assert.Equal(t, wasm, exported)
+	})
+
+	t.Run("InvalidGlobals", func(t *testing.T) {
+		wasm := append(append([]byte{}, prefix...), 0x06, 0x03, 0x01, 0x7f, 0x01)
+
+		_, _, err := exportMutableGlobals(wasm)
+		assert.ErrorIs(t, err, errInvalidWasmBinary)
+	})
+}
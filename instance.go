@@ -19,11 +19,19 @@ package scale
 import (
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 
 	interfaces "github.com/loopholelabs/scale-signature-interfaces"
 )
 
+var (
+	ErrInstanceBusy      = errors.New("instance is busy")
+	ErrInstanceReentrant = errors.New("instance is already running in this call chain")
+)
+
 // Instance is a single instance of a Scale Function chain
 type Instance[T interfaces.Signature] struct {
 	// runtime is the runtime that this instance belongs to
@@ -37,12 +45,32 @@ type Instance[T interfaces.Signature] struct {
 
 	// next is the next function in the chain for this instance
 	next Next[T]
+
+	// generation is the generation of the function chain the instance was created from
+	generation uint64
+
+	// templates are the templates of the function chain the instance was created from,
+	// which are not closed while the instance uses them (see Scale.releaseChain)
+	templates []*template[T]
+	closeOnce sync.Once
+
+	// recorder is called with a Recording of every run, if it is set
+	recorder    atomic.Pointer[func(*Recording)]
+	recordingMu sync.Mutex
+	recording   *Recording
+
+	// replay returns the recorded responses to host calls when the instance is replaying a Recording
+	replay *replayer
+
+	// busy is held by the call that is currently using the instance, if the instance is exclusive
+	busy chan struct{}
 }
 
 func newInstance[T interfaces.Signature](ctx context.Context, runtime *Scale[T], next ...Next[T]) (*Instance[T], error) {
 	instance := &Instance[T]{
 		runtime:    runtime,
 		identifier: make([]byte, 16),
+		busy:       make(chan struct{}, 1),
 	}
 
 	_, err := rand.Read(instance.identifier)
@@ -50,20 +78,15 @@ func newInstance[T interfaces.Signature](ctx context.Context, runtime *Scale[T],
 		return nil, err
 	}
 
-	if len(next) > 0 && next[0] != nil {
-		instance.next = next[0]
-	} else {
-		instance.next = func(ctx T) (T, error) {
-			return ctx, nil
-		}
-	}
+	instance.setNext(next...)
 
-	previousFunction := instance.head
-	nextTemplate := instance.runtime.head
+	instance.templates, instance.generation = instance.runtime.acquireChain()
 
-	for nextTemplate != nil {
-		fn, err := newFunction(ctx, instance, nextTemplate)
+	var previousFunction *function[T]
+	for _, t := range instance.templates {
+		fn, err := newFunction(ctx, instance, t)
 		if err != nil {
+			instance.Close()
 			return nil, fmt.Errorf("failed to create function: %w", err)
 		}
 		if instance.head == nil {
@@ -73,23 +96,141 @@ func newInstance[T interfaces.Signature](ctx context.Context, runtime *Scale[T],
 			previousFunction.next = fn
 		}
 		previousFunction = fn
-		nextTemplate = nextTemplate.next
 	}
 
 	return instance, nil
 }
 
+// Close closes the stateful modules of the instance, and allows the functions that the instance was created from
+// to be closed once they are no longer part of the function chain (see Scale.ReplaceFunction and Scale.RemoveFunction).
+//
+// The instance must not be running when it is closed, and must not be used afterwards.
+func (i *Instance[T]) Close() {
+	i.closeOnce.Do(func() {
+		for fn := i.head; fn != nil; fn = fn.next {
+			fn.close()
+		}
+		i.runtime.releaseChain(i.templates)
+	})
+}
+
+// setNext sets the optional next function of the instance, which
+// returns the signature unchanged if it is not provided
+func (i *Instance[T]) setNext(next ...Next[T]) {
+	if len(next) > 0 && next[0] != nil {
+		i.next = next[0]
+	} else {
+		i.next = func(ctx T) (T, error) {
+			return ctx, nil
+		}
+	}
+}
+
+// Run runs the function chain with the given signature
+//
+// Instances whose functions are all stateless can be run from multiple goroutines at once. Otherwise,
+// concurrent calls are queued and run one after the other, and calls made with a context that was passed
+// down from a call which is using the instance fail with ErrInstanceReentrant instead of waiting for it.
+//
+// Next functions are not given the context of the call, so calling Run from the Next function of the same
+// instance is queued like any other concurrent call, and only fails once the given context is done.
 func (i *Instance[T]) Run(ctx context.Context, signature T) error {
-	i.runtime.resetExtensions()
+	unlock, err := i.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if i.exclusive() {
+		ctx = i.withRunning(ctx)
+	}
 
-	m, err := i.head.getModule(signature)
+	err = i.runtime.acquire()
 	if err != nil {
-		return fmt.Errorf("failed to get module for function '%s': %w", i.head.template.identifier, err)
+		return err
 	}
-	err = m.run(ctx)
-	i.head.putModule(m)
+	defer i.runtime.release()
+
+	i.runtime.resetExtensions()
+	ctx, scope := i.runtime.withScope(ctx)
+	defer i.runtime.releaseExtensions(scope)
+
+	var recording *Recording
+	recorder := i.loadRecorder()
+	if recorder != nil {
+		recording = i.startRecording(signature.Write())
+	}
+
+	err = i.head.run(ctx, signature)
 	if err != nil {
-		return fmt.Errorf("failed to run function '%s': %w", i.head.template.identifier, err)
+		err = wrapRunError(i.head.template.identifier, err)
+		i.finishRecording(recorder, recording, nil, err)
+		return err
+	}
+	if recording != nil {
+		i.finishRecording(recorder, recording, signature.Write(), nil)
 	}
 	return nil
 }
+
+// exclusive returns true if the instance can only be used by a single call at a time,
+// which is the case if it has stateful functions or is recording or replaying its calls
+func (i *Instance[T]) exclusive() bool {
+	return !i.stateless() || i.loadRecorder() != nil || i.replay != nil
+}
+
+// lock waits until the instance can be used by the caller if it is exclusive,
+// and returns a function that releases the instance once the caller is done with it
+func (i *Instance[T]) lock(ctx context.Context) (func(), error) {
+	if !i.exclusive() {
+		return func() {}, nil
+	}
+	if i.running(ctx) {
+		// The call using the instance cannot complete before this call does,
+		// so waiting for the instance would never succeed
+		return nil, ErrInstanceReentrant
+	}
+	select {
+	case i.busy <- struct{}{}:
+		return func() { <-i.busy }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type runningInstancesKey struct{}
+
+// runningInstance is an element of the list of exclusive instances that are running in a call chain
+type runningInstance struct {
+	instance any
+	caller   *runningInstance
+}
+
+// withRunning returns a context that marks the instance as running for the call it is passed down to
+func (i *Instance[T]) withRunning(ctx context.Context) context.Context {
+	caller, _ := ctx.Value(runningInstancesKey{}).(*runningInstance)
+	return context.WithValue(ctx, runningInstancesKey{}, &runningInstance{instance: i, caller: caller})
+}
+
+// running returns true if the given context belongs to a call that is using the instance
+func (i *Instance[T]) running(ctx context.Context) bool {
+	r, _ := ctx.Value(runningInstancesKey{}).(*runningInstance)
+	for ; r != nil; r = r.caller {
+		if r.instance == any(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// tryLock is like lock, but returns ErrInstanceBusy instead of waiting if the instance is in use
+func (i *Instance[T]) tryLock() (func(), error) {
+	if !i.exclusive() {
+		return func() {}, nil
+	}
+	select {
+	case i.busy <- struct{}{}:
+		return func() { <-i.busy }, nil
+	default:
+		return nil, ErrInstanceBusy
+	}
+}
@@ -0,0 +1,142 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceConcurrentRun(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)))
+
+	// The next function blocks so that the calls would overlap if they were not serialized
+	instance, err := r.Instance(func(sig *testSignature) (*testSignature, error) {
+		time.Sleep(time.Millisecond)
+		sig.data = append(sig.data, '!')
+		return sig, nil
+	})
+	require.NoError(t, err)
+
+	const goroutines, runs = 8, 5
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for g := 0; g < goroutines; g++ {
+		go func(g int) {
+			defer wg.Done()
+			<-start
+			for i := 0; i < runs; i++ {
+				input := fmt.Sprintf("n-%d-%d", g, i)
+				output, err := runTestInstance(t, instance, input)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, input+"!", output)
+			}
+		}(g)
+	}
+	close(start)
+	wg.Wait()
+
+	require.NoError(t, r.Close(context.Background()))
+}
+
+func TestInstanceQueue(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	looping := instance.RunAsync(ctx, newTestSignatures("l")[0])
+
+	// Wait until the looping call holds the instance
+	require.Eventually(t, func() bool { return len(instance.busy) == 1 }, time.Second, time.Millisecond)
+
+	waitCtx, waitCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer waitCancel()
+	err = instance.Run(waitCtx, newTestSignatures("echo")[0])
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	queued := instance.RunAsync(context.Background(), newTestSignatures("echo")[0])
+	cancel()
+	assert.ErrorIs(t, (<-looping).Err, context.Canceled)
+
+	result := <-queued
+	require.NoError(t, result.Err)
+	assert.Equal(t, "echo", string(result.Signature.data))
+
+	require.NoError(t, r.Close(context.Background()))
+}
+
+func TestInstanceReentrancy(t *testing.T) {
+	for _, stateless := range []bool{true, false} {
+		r := newTestScale(t, NewConfig(newTestSignature).
+			WithFunction(testGuest(t, "first", stateless)).
+			WithSnapshots(true))
+
+		var instance *Instance[*testSignature]
+		var nestedErr, markedErr, snapshotErr error
+		instance, err := r.Instance(func(sig *testSignature) (*testSignature, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			nestedErr = instance.Run(ctx, newTestSignatures("echo")[0])
+
+			// A context passed down from the running call marks the instance as running
+			markedErr = instance.Run(instance.withRunning(context.Background()), newTestSignatures("echo")[0])
+
+			_, snapshotErr = instance.Snapshot()
+			return sig, nil
+		})
+		require.NoError(t, err)
+
+		output, err := runTestInstance(t, instance, "nn")
+		require.NoError(t, err)
+		assert.Equal(t, "nn", output)
+		if stateless {
+			assert.NoError(t, nestedErr)
+			assert.NoError(t, markedErr)
+			assert.NoError(t, snapshotErr)
+		} else {
+			// The nested call waits for the current call to complete, which cannot happen until it returns
+			assert.ErrorIs(t, nestedErr, context.DeadlineExceeded)
+			assert.ErrorIs(t, markedErr, ErrInstanceReentrant)
+			assert.ErrorIs(t, snapshotErr, ErrInstanceBusy)
+		}
+
+		// The instance can be used again once the call completed
+		output, err = runTestInstance(t, instance, "echo")
+		require.NoError(t, err)
+		assert.Equal(t, "echo", output)
+		_, err = instance.Snapshot()
+		require.NoError(t, err)
+
+		require.NoError(t, r.Close(context.Background()))
+	}
+}
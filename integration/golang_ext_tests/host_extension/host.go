@@ -40,10 +40,31 @@ func (he *hostExt) Init() map[string]extension.InstallableFunc {
 func (he *hostExt) Reset() {
 	// Reset any instances that have been created.
 
-	he.host.instances_Example = make(map[uint64]Example)
+	he.host.instancesLock_Example.Lock()
+	he.host.instances_Example = make(map[uint64]map[uint64]Example)
+	he.host.instancesLock_Example.Unlock()
 
 }
 
+// Release is called by runtimes that scope extension state to a single call once the call has completed,
+// instead of calling Reset before every call.
+func (he *hostExt) Release(scope uint64) {
+	// Release any instances that have been created during the call.
+
+	he.host.instancesLock_Example.Lock()
+	delete(he.host.instances_Example, scope)
+	he.host.instancesLock_Example.Unlock()
+
+}
+
+// scope returns the identifier of the call that a function was called from, if the runtime scopes extension state to a single call.
+func scope(mem extension.ModuleMemory) uint64 {
+	if s, ok := mem.(interface{ Scope() uint64 }); ok {
+		return s.Scope()
+	}
+	return 0
+}
+
 func New(impl Interface) extension.Extension {
 	hostWrapper := &Host{impl: impl}
 
@@ -55,7 +76,7 @@ func New(impl Interface) extension.Extension {
 
 	fns["ext_b30af2dd8561988edd7b281ad5c1b84487072727a8ad0e490a87be0a66b037d7_World"] = hostWrapper.host_ext_b30af2dd8561988edd7b281ad5c1b84487072727a8ad0e490a87be0a66b037d7_World
 
-	hostWrapper.instances_Example = make(map[uint64]Example)
+	hostWrapper.instances_Example = make(map[uint64]map[uint64]Example)
 
 	fns["ext_b30af2dd8561988edd7b281ad5c1b84487072727a8ad0e490a87be0a66b037d7_Example_Hello"] = hostWrapper.host_ext_b30af2dd8561988edd7b281ad5c1b84487072727a8ad0e490a87be0a66b037d7_Example_Hello
 
@@ -70,7 +91,7 @@ type Host struct {
 
 	gid_Example           uint64
 	instancesLock_Example sync.Mutex
-	instances_Example     map[uint64]Example
+	instances_Example     map[uint64]map[uint64]Example
 }
 
 // Global functions
@@ -96,8 +117,12 @@ func (h *Host) host_ext_b30af2dd8561988edd7b281ad5c1b84487072727a8ad0e490a87be0a
 	}
 
 	id := atomic.AddUint64(&h.gid_Example, 1)
+	s := scope(mem)
 	h.instancesLock_Example.Lock()
-	h.instances_Example[id] = r
+	if h.instances_Example[s] == nil {
+		h.instances_Example[s] = make(map[uint64]Example)
+	}
+	h.instances_Example[s][id] = r
 	h.instancesLock_Example.Unlock()
 
 	// Return the ID
@@ -144,7 +169,7 @@ func (h *Host) host_ext_b30af2dd8561988edd7b281ad5c1b84487072727a8ad0e490a87be0a
 
 func (h *Host) host_ext_b30af2dd8561988edd7b281ad5c1b84487072727a8ad0e490a87be0a66b037d7_Example_Hello(mem extension.ModuleMemory, resize extension.Resizer, params []uint64) {
 	h.instancesLock_Example.Lock()
-	r, ok := h.instances_Example[params[0]]
+	r, ok := h.instances_Example[scope(mem)][params[0]]
 	h.instancesLock_Example.Unlock()
 	if !ok {
 		hostError(mem, resize, errors.New("Instance ID not found!"))
@@ -375,7 +375,9 @@ func TestExtTypescriptHostGolangGuest(t *testing.T) {
 	require.NoError(t, err)
 
 	schema := compileExtGolangGuest(t)
-	err = os.WriteFile(wd+"/golang.scale", schema.Encode(), 0644)
+	encoded, err := schema.Encode()
+	require.NoError(t, err)
+	err = os.WriteFile(wd+"/golang.scale", encoded, 0644)
 	require.NoError(t, err)
 	t.Cleanup(func() {
 		err = os.Remove(wd + "/golang.scale")
@@ -395,7 +397,9 @@ func TestExtTypescriptHostRustGuest(t *testing.T) {
 	require.NoError(t, err)
 
 	schema := compileExtRustGuest(t)
-	err = os.WriteFile(wd+"/rust.scale", schema.Encode(), 0644)
+	encoded, err := schema.Encode()
+	require.NoError(t, err)
+	err = os.WriteFile(wd+"/rust.scale", encoded, 0644)
 	require.NoError(t, err)
 	t.Cleanup(func() {
 		err = os.Remove(wd + "/rust.scale")
@@ -415,7 +419,9 @@ func TestExtTypescriptHostTypescriptGuest(t *testing.T) {
 	require.NoError(t, err)
 
 	schema := compileExtTypescriptGuest(t)
-	err = os.WriteFile(wd+"/typescript.scale", schema.Encode(), 0644)
+	encoded, err := schema.Encode()
+	require.NoError(t, err)
+	err = os.WriteFile(wd+"/typescript.scale", encoded, 0644)
 	require.NoError(t, err)
 	t.Cleanup(func() {
 		err = os.Remove(wd + "/typescript.scale")
@@ -311,7 +311,9 @@ func TestTypescriptHostTypescriptGuest(t *testing.T) {
 	require.NoError(t, err)
 
 	schema := compileTypescriptGuest(t)
-	err = os.WriteFile(wd+"/typescript.scale", schema.Encode(), 0644)
+	encoded, err := schema.Encode()
+	require.NoError(t, err)
+	err = os.WriteFile(wd+"/typescript.scale", encoded, 0644)
 	require.NoError(t, err)
 	t.Cleanup(func() {
 		err = os.Remove(wd + "/typescript.scale")
@@ -331,7 +333,9 @@ func TestTypescriptHostGolangGuest(t *testing.T) {
 	require.NoError(t, err)
 
 	schema := compileGolangGuest(t)
-	err = os.WriteFile(wd+"/golang.scale", schema.Encode(), 0644)
+	encoded, err := schema.Encode()
+	require.NoError(t, err)
+	err = os.WriteFile(wd+"/golang.scale", encoded, 0644)
 	require.NoError(t, err)
 	t.Cleanup(func() {
 		err = os.Remove(wd + "/golang.scale")
@@ -351,7 +355,9 @@ func TestTypescriptHostRustGuest(t *testing.T) {
 	require.NoError(t, err)
 
 	schema := compileRustGuest(t)
-	err = os.WriteFile(wd+"/rust.scale", schema.Encode(), 0644)
+	encoded, err := schema.Encode()
+	require.NoError(t, err)
+	err = os.WriteFile(wd+"/rust.scale", encoded, 0644)
 	require.NoError(t, err)
 	t.Cleanup(func() {
 		err = os.Remove(wd + "/rust.scale")
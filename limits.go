@@ -0,0 +1,178 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// maxMemoryPages is the maximum number of pages a 32-bit wasm memory can have
+	maxMemoryPages = 65536
+
+	// memoryPageSize is the size of a single wasm memory page
+	memoryPageSize = 65536
+
+	wasmHeaderSize      = 8
+	wasmMemorySectionID = 5
+
+	limitsHasMax = 0x01
+)
+
+var (
+	errInvalidWasmBinary = errors.New("invalid wasm binary")
+	errNoMemorySection   = errors.New("wasm binary does not define a memory")
+	errImportedMemory    = errors.New("memory limits are not supported for wasm binaries that import their memory")
+)
+
+// limitMemoryPages rewrites the memory section of the given wasm binary so that every
+// memory it defines has a maximum of at most maxPages pages. Guests that attempt to grow
+// their memory beyond this limit will have the `memory.grow` instruction fail.
+//
+// Binaries that import their memory instead of defining it cannot be limited,
+// and errImportedMemory is returned for them. The original binary is not modified.
+func limitMemoryPages(wasm []byte, maxPages uint32) ([]byte, error) {
+	if len(wasm) < wasmHeaderSize {
+		return nil, errInvalidWasmBinary
+	}
+
+	importedMemory := false
+	offset := wasmHeaderSize
+	for offset < len(wasm) {
+		sectionStart := offset
+		id := wasm[offset]
+		offset++
+		size, n := binary.Uvarint(wasm[offset:])
+		if n <= 0 || uint64(len(wasm)-offset-n) < size {
+			return nil, errInvalidWasmBinary
+		}
+		offset += n
+		contentStart := offset
+		offset += int(size)
+
+		if id == wasmImportSectionID {
+			var err error
+			importedMemory, err = importsMemory(wasm[contentStart:offset])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if id != wasmMemorySectionID {
+			continue
+		}
+
+		content, err := limitMemorySection(wasm[contentStart:offset], maxPages)
+		if err != nil {
+			return nil, err
+		}
+
+		out := bytes.NewBuffer(make([]byte, 0, len(wasm)+len(content)-int(size)))
+		out.Write(wasm[:sectionStart])
+		out.WriteByte(wasmMemorySectionID)
+		out.Write(binary.AppendUvarint(nil, uint64(len(content))))
+		out.Write(content)
+		out.Write(wasm[offset:])
+		return out.Bytes(), nil
+	}
+
+	if importedMemory {
+		return nil, errImportedMemory
+	}
+	return nil, errNoMemorySection
+}
+
+// importsMemory returns true if the given import section imports a memory
+func importsMemory(section []byte) (bool, error) {
+	r := &wasmReader{b: section}
+	count := r.uvarint()
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		r.skip(int(r.uvarint())) // module
+		r.skip(int(r.uvarint())) // name
+		switch r.byte() {
+		case wasmExternFunction:
+			r.uvarint()
+		case wasmExternTable:
+			r.byte()
+			r.limits()
+		case wasmExternMemory:
+			return true, r.err
+		case wasmExternGlobal:
+			r.byte()
+			r.byte()
+		case wasmExternTag:
+			r.byte()
+			r.uvarint()
+		default:
+			return false, errInvalidWasmBinary
+		}
+	}
+	return false, r.done()
+}
+
+// limitMemorySection re-encodes the contents of a memory section with the given maximum page limit
+func limitMemorySection(section []byte, maxPages uint32) ([]byte, error) {
+	count, n := binary.Uvarint(section)
+	if n <= 0 {
+		return nil, errInvalidWasmBinary
+	}
+	offset := n
+
+	out := binary.AppendUvarint(nil, count)
+	for i := uint64(0); i < count; i++ {
+		if offset >= len(section) {
+			return nil, errInvalidWasmBinary
+		}
+		flags := section[offset]
+		offset++
+
+		minimum, n := binary.Uvarint(section[offset:])
+		if n <= 0 {
+			return nil, errInvalidWasmBinary
+		}
+		offset += n
+
+		maximum := uint64(maxPages)
+		if flags&limitsHasMax != 0 {
+			existing, n := binary.Uvarint(section[offset:])
+			if n <= 0 {
+				return nil, errInvalidWasmBinary
+			}
+			offset += n
+			if existing < maximum {
+				maximum = existing
+			}
+		}
+
+		if minimum > maximum {
+			return nil, fmt.Errorf("%w: function requires at least %d pages but is limited to %d", ErrMemoryLimitExceeded, minimum, maximum)
+		}
+
+		out = append(out, flags|limitsHasMax)
+		out = binary.AppendUvarint(out, minimum)
+		out = binary.AppendUvarint(out, maximum)
+	}
+
+	if offset != len(section) {
+		return nil, errInvalidWasmBinary
+	}
+
+	return out, nil
+}
@@ -0,0 +1,157 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tetratelabs/wazero"
+)
+
+var wasmHeader = []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+func TestLimitMemoryPages(t *testing.T) {
+	t.Run("NoMaximum", func(t *testing.T) {
+		// (memory (export "memory") 2) followed by a custom section
+		wasm := append(append([]byte{}, wasmHeader...), 0x05, 0x03, 0x01, 0x00, 0x02)
+		wasm = append(wasm, 0x07, 0x0a, 0x01, 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00)
+		wasm = append(wasm, 0x00, 0x03, 0x01, 'a', 0x00)
+
+		limited, err := limitMemoryPages(wasm, 16)
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x05, 0x04, 0x01, 0x01, 0x02, 0x10}, limited[len(wasmHeader):len(wasmHeader)+6])
+		assert.Equal(t, wasm[len(wasmHeader)+5:], limited[len(wasmHeader)+6:])
+
+		ctx := context.Background()
+		runtime := wazero.NewRuntime(ctx)
+		t.Cleanup(func() {
+			_ = runtime.Close(ctx)
+		})
+
+		instantiated, err := runtime.Instantiate(ctx, limited)
+		require.NoError(t, err)
+
+		_, ok := instantiated.Memory().Grow(14)
+		assert.True(t, ok)
+
+		_, ok = instantiated.Memory().Grow(1)
+		assert.False(t, ok)
+	})
+
+	t.Run("LowerExistingMaximum", func(t *testing.T) {
+		// (memory 1 4)
+		wasm := append(append([]byte{}, wasmHeader...), 0x05, 0x04, 0x01, 0x01, 0x01, 0x04)
+
+		limited, err := limitMemoryPages(wasm, 16)
+		require.NoError(t, err)
+		assert.Equal(t, wasm, limited)
+	})
+
+	t.Run("MinimumAboveLimit", func(t *testing.T) {
+		// (memory 32)
+		wasm := append(append([]byte{}, wasmHeader...), 0x05, 0x03, 0x01, 0x00, 0x20)
+
+		_, err := limitMemoryPages(wasm, 16)
+		assert.ErrorIs(t, err, ErrMemoryLimitExceeded)
+	})
+
+	t.Run("NoMemory", func(t *testing.T) {
+		_, err := limitMemoryPages(wasmHeader, 16)
+		assert.ErrorIs(t, err, errNoMemorySection)
+	})
+
+	t.Run("ImportedMemory", func(t *testing.T) {
+		// (import "env" "f" (func (type 0))) (import "env" "memory" (memory 1))
+		wasm := append(append([]byte{}, wasmHeader...), 0x02, 0x17, 0x02)
+		wasm = append(wasm, 0x03, 'e', 'n', 'v', 0x01, 'f', 0x00, 0x00)
+		wasm = append(wasm, 0x03, 'e', 'n', 'v', 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00, 0x01)
+
+		_, err := limitMemoryPages(wasm, 16)
+		assert.ErrorIs(t, err, errImportedMemory)
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		wasm := append(append([]byte{}, wasmHeader...), 0x05, 0x08, 0x01)
+
+		_, err := limitMemoryPages(wasm, 16)
+		assert.ErrorIs(t, err, errInvalidWasmBinary)
+	})
+}
+
+func TestExecutionLimit(t *testing.T) {
+	for _, stateless := range []bool{true, false} {
+		r := newTestScale(t, NewConfig(newTestSignature).
+			WithFunction(testGuest(t, "first", stateless)).
+			WithExecutionLimit(50*time.Millisecond))
+
+		instance, err := r.Instance()
+		require.NoError(t, err)
+
+		_, err = runTestInstance(t, instance, "l")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrExecutionLimitExceeded))
+		assert.ErrorContains(t, err, "function 'first:latest' did not complete within 50ms")
+
+		output, err := runTestInstance(t, instance, "echo")
+		require.NoError(t, err)
+		assert.Equal(t, "echo", output)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	for _, stateless := range []bool{true, false} {
+		r := newTestScale(t, NewConfig(newTestSignature).
+			WithFunctionConfig(testGuest(t, "first", stateless), NewFunctionConfig().WithMaxMemoryPages(4)))
+
+		instance, err := r.Instance()
+		require.NoError(t, err)
+
+		_, err = runTestInstance(t, instance, "g")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrMemoryLimitExceeded))
+		assert.ErrorContains(t, err, "function 'first:latest' failed after reaching its limit of 4 memory pages")
+
+		var guestErr *GuestError
+		assert.True(t, errors.As(err, &guestErr))
+	}
+}
+
+func TestSignatureSizeLimit(t *testing.T) {
+	for _, stateless := range []bool{true, false} {
+		r := newTestScale(t, NewConfig(newTestSignature).
+			WithFunctionConfig(testGuest(t, "first", stateless), NewFunctionConfig().WithMaxSignatureSize(8)))
+
+		instance, err := r.Instance()
+		require.NoError(t, err)
+
+		_, err = runTestInstance(t, instance, "signature is too large")
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrSignatureSizeExceeded))
+		assert.ErrorContains(t, err, "signature for function 'first:latest' is 22 bytes, limit is 8 bytes")
+
+		output, err := runTestInstance(t, instance, "echo")
+		require.NoError(t, err)
+		assert.Equal(t, "echo", output)
+	}
+}
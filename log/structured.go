@@ -0,0 +1,261 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream is the output stream that a guest wrote a log line to
+type Stream string
+
+const (
+	Stdout Stream = "stdout"
+	Stderr Stream = "stderr"
+)
+
+// The keys of the attributes that are added to every record emitted by a Logger
+const (
+	FunctionKey = "function"
+	TagKey      = "tag"
+	InstanceKey = "instance"
+	StreamKey   = "stream"
+)
+
+// MaxLineSize is the maximum size of a single line, longer lines are split into multiple records
+const MaxLineSize = 64 * 1024
+
+var _ io.Writer = (*Logger)(nil)
+
+// Logger is an io.Writer that buffers the output of a guest function line by line,
+// and emits every line as a record through a slog.Handler
+//
+// Records carry the function's name and tag, the ID of the instance the function is running in,
+// and the stream the line was written to. Lines that are JSON objects have their fields added
+// to the record as attributes, with the `msg` (or `message`), `level` and `time` fields used
+// as the record's message, level and time. Other lines are emitted at Info level for stdout and Error level for stderr.
+type Logger struct {
+	mu       sync.Mutex
+	handler  slog.Handler
+	level    slog.Level
+	instance string
+	buf      []byte
+}
+
+// NewLogger returns a Logger that emits records for the given function and stream through the given handler
+func NewLogger(handler slog.Handler, name string, tag string, stream Stream) *Logger {
+	level := slog.LevelInfo
+	if stream == Stderr {
+		level = slog.LevelError
+	}
+
+	return &Logger{
+		handler: handler.WithAttrs([]slog.Attr{
+			slog.String(FunctionKey, name),
+			slog.String(TagKey, tag),
+			slog.String(StreamKey, string(stream)),
+		}),
+		level: level,
+	}
+}
+
+// SetInstance sets the ID of the instance that is currently using the function
+func (l *Logger) SetInstance(id string) {
+	l.mu.Lock()
+	l.instance = id
+	l.mu.Unlock()
+}
+
+// Write buffers the given output and emits a record for every complete line
+func (l *Logger) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = append(l.buf, p...)
+	for {
+		index := bytes.IndexByte(l.buf, '\n')
+		if index < 0 {
+			if len(l.buf) >= MaxLineSize {
+				l.emit(l.buf[:MaxLineSize])
+				l.buf = append(l.buf[:0], l.buf[MaxLineSize:]...)
+				continue
+			}
+			break
+		}
+		l.emit(l.buf[:index])
+		l.buf = l.buf[index+1:]
+	}
+
+	if len(l.buf) == 0 {
+		l.buf = l.buf[:0:0]
+	}
+
+	return len(p), nil
+}
+
+// Flush emits a record for any buffered output that does not end with a newline
+func (l *Logger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.buf) > 0 {
+		l.emit(l.buf)
+		l.buf = nil
+	}
+}
+
+// emit emits a record for a single line, the caller must hold mu
+func (l *Logger) emit(line []byte) {
+	line = bytes.TrimSuffix(line, []byte{'\r'})
+	if len(bytes.TrimSpace(line)) == 0 {
+		return
+	}
+
+	record := slog.NewRecord(time.Now(), l.level, string(line), 0)
+	if fields, ok := parseJSON(line); ok {
+		record = fields.record(record)
+	}
+
+	ctx := context.Background()
+	if !l.handler.Enabled(ctx, record.Level) {
+		return
+	}
+
+	if l.instance != "" {
+		record.AddAttrs(slog.String(InstanceKey, l.instance))
+	}
+
+	_ = l.handler.Handle(ctx, record)
+}
+
+type jsonFields map[string]any
+
+// parseJSON parses a line that contains a JSON object
+func parseJSON(line []byte) (jsonFields, bool) {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var fields jsonFields
+	if decoder.Decode(&fields) != nil || decoder.More() {
+		return nil, false
+	}
+	return fields, true
+}
+
+// record returns a copy of the given record with its message, level, time and attributes taken from the fields
+func (f jsonFields) record(record slog.Record) slog.Record {
+	message := record.Message
+	for _, key := range []string{"msg", "message"} {
+		if v, ok := f[key].(string); ok {
+			message = v
+			delete(f, key)
+			break
+		}
+	}
+
+	level := record.Level
+	for _, key := range []string{"level", "lvl"} {
+		if v, ok := f[key].(string); ok {
+			if parsed, ok := parseLevel(v); ok {
+				level = parsed
+				delete(f, key)
+			}
+			break
+		}
+	}
+
+	t := record.Time
+	if v, ok := f["time"].(string); ok {
+		if parsed, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			t = parsed
+			delete(f, "time")
+		}
+	}
+
+	parsed := slog.NewRecord(t, level, message, 0)
+	parsed.AddAttrs(attrs(f)...)
+	return parsed
+}
+
+// parseLevel parses a log level, accepting the level names used by most logging libraries
+func parseLevel(s string) (slog.Level, bool) {
+	switch strings.ToLower(s) {
+	case "trace", "debug":
+		return slog.LevelDebug, true
+	case "info", "notice":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error", "err", "fatal", "critical", "panic":
+		return slog.LevelError, true
+	}
+	var level slog.Level
+	if level.UnmarshalText([]byte(s)) != nil {
+		return 0, false
+	}
+	return level, true
+}
+
+// attrs converts decoded JSON fields into attributes, sorted by key
+func attrs(fields map[string]any) []slog.Attr {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, attr(key, fields[key]))
+	}
+	return attrs
+}
+
+func attr(key string, value any) slog.Attr {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return slog.Int64(key, i)
+		}
+		if f, err := v.Float64(); err == nil {
+			return slog.Float64(key, f)
+		}
+		return slog.String(key, v.String())
+	case map[string]any:
+		nested := attrs(v)
+		args := make([]any, 0, len(nested))
+		for _, a := range nested {
+			args = append(args, a)
+		}
+		return slog.Group(key, args...)
+	default:
+		return slog.Any(key, v)
+	}
+}
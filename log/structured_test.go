@@ -0,0 +1,75 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package log
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	handler := slog.NewJSONHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+
+	logger := NewLogger(handler, "example", "latest", Stdout)
+	logger.SetInstance("0123")
+
+	_, err := logger.Write([]byte("first line\nsecond "))
+	require.NoError(t, err)
+	_, err = logger.Write([]byte("line\r\n\n{\"msg\":\"structured\",\"level\":\"warn\",\"count\":3,\"ratio\":0.5,\"nested\":{\"ok\":true}}\npartial"))
+	require.NoError(t, err)
+	logger.Flush()
+
+	logger = NewLogger(handler, "example", "latest", Stderr)
+	_, err = logger.Write([]byte("{not json\n"))
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, `{"level":"INFO","msg":"first line","function":"example","tag":"latest","stream":"stdout","instance":"0123"}`, lines[0])
+	assert.Equal(t, `{"level":"INFO","msg":"second line","function":"example","tag":"latest","stream":"stdout","instance":"0123"}`, lines[1])
+	assert.Equal(t, `{"level":"WARN","msg":"structured","function":"example","tag":"latest","stream":"stdout","count":3,"nested":{"ok":true},"ratio":0.5,"instance":"0123"}`, lines[2])
+	assert.Equal(t, `{"level":"INFO","msg":"partial","function":"example","tag":"latest","stream":"stdout","instance":"0123"}`, lines[3])
+	assert.Equal(t, `{"level":"ERROR","msg":"{not json","function":"example","tag":"latest","stream":"stderr"}`, lines[4])
+}
+
+func TestLoggerLongLine(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(slog.NewTextHandler(&buf, nil), "example", "latest", Stdout)
+
+	_, err := logger.Write(bytes.Repeat([]byte{'a'}, MaxLineSize+1))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+
+	logger.Flush()
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+}
@@ -0,0 +1,66 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"time"
+)
+
+// Metrics receives runtime metrics from a Scale Runtime
+//
+// Every method is called with the identifier (<name>:<tag>) of the function
+// the measurement belongs to. Implementations must be safe for concurrent use
+// and should not block, since they are called on the execution path.
+type Metrics interface {
+	// Run is called after every call to a function's `run` export
+	// with the time it took and the resulting error (if any)
+	Run(function string, duration time.Duration, err error)
+
+	// Initialize is called after every module instantiation (including
+	// the call to the `initialize` export) with the time it took and the resulting error (if any)
+	Initialize(function string, duration time.Duration, err error)
+
+	// PoolHit is called when a module for a stateless function is retrieved from its pool
+	PoolHit(function string)
+
+	// PoolMiss is called when a new module had to be instantiated for a stateless function
+	PoolMiss(function string)
+
+	// Resize is called with the number of bytes written into a function's
+	// memory through its `resize` export
+	Resize(function string, bytes int)
+
+	// Next is called every time a function calls the `next` host function
+	Next(function string)
+
+	// Recycle is called every time a module is discarded because
+	// it trapped or failed too many times in a row
+	Recycle(function string)
+}
+
+var _ Metrics = (*noopMetrics)(nil)
+
+// noopMetrics is the Metrics implementation used when no Metrics are configured
+type noopMetrics struct{}
+
+func (noopMetrics) Run(string, time.Duration, error)        {}
+func (noopMetrics) Initialize(string, time.Duration, error) {}
+func (noopMetrics) PoolHit(string)                          {}
+func (noopMetrics) PoolMiss(string)                         {}
+func (noopMetrics) Resize(string, int)                      {}
+func (noopMetrics) Next(string)                             {}
+func (noopMetrics) Recycle(string)                          {}
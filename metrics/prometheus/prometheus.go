@@ -0,0 +1,165 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+// Package prometheus implements a Scale Runtime Metrics adapter
+// that exposes the runtime metrics as Prometheus collectors.
+package prometheus
+
+import (
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/loopholelabs/scale"
+)
+
+const (
+	// DefaultNamespace is the namespace used for the metrics if none is provided
+	DefaultNamespace = "scale"
+
+	functionLabel = "function"
+)
+
+var _ scale.Metrics = (*Metrics)(nil)
+var _ prom.Collector = (*Metrics)(nil)
+
+// Metrics implements the scale.Metrics interface using Prometheus collectors
+//
+// It is itself a prom.Collector, so it can be registered directly with a prom.Registerer.
+type Metrics struct {
+	invocations        *prom.CounterVec
+	errors             *prom.CounterVec
+	runDuration        *prom.HistogramVec
+	instantiations     *prom.CounterVec
+	initializeErrors   *prom.CounterVec
+	initializeDuration *prom.HistogramVec
+	poolHits           *prom.CounterVec
+	poolMisses         *prom.CounterVec
+	resizeBytes        *prom.CounterVec
+	nextCalls          *prom.CounterVec
+	recycles           *prom.CounterVec
+}
+
+// New returns a new Metrics adapter with all metrics prefixed with the given namespace
+func New(namespace string) *Metrics {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	counter := func(name string, help string) *prom.CounterVec {
+		return prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+		}, []string{functionLabel})
+	}
+
+	histogram := func(name string, help string) *prom.HistogramVec {
+		return prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      name,
+			Help:      help,
+			Buckets:   prom.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{functionLabel})
+	}
+
+	return &Metrics{
+		invocations:        counter("function_invocations_total", "Number of times a function's run export was called"),
+		errors:             counter("function_errors_total", "Number of function runs that returned an error"),
+		runDuration:        histogram("function_run_duration_seconds", "Duration of a function's run export"),
+		instantiations:     counter("module_instantiations_total", "Number of modules instantiated for a function"),
+		initializeErrors:   counter("module_initialize_errors_total", "Number of module instantiations that returned an error"),
+		initializeDuration: histogram("module_initialize_duration_seconds", "Duration of a module's instantiation, including its initialize export"),
+		poolHits:           counter("module_pool_hits_total", "Number of modules retrieved from a function's module pool"),
+		poolMisses:         counter("module_pool_misses_total", "Number of modules that had to be instantiated because a function's module pool was empty"),
+		resizeBytes:        counter("resize_bytes_total", "Number of bytes written into a function's memory through its resize export"),
+		nextCalls:          counter("next_calls_total", "Number of calls to the next host function"),
+		recycles:           counter("module_recycles_total", "Number of modules discarded because they trapped or failed too many times in a row"),
+	}
+}
+
+func (m *Metrics) collectors() []prom.Collector {
+	return []prom.Collector{
+		m.invocations,
+		m.errors,
+		m.runDuration,
+		m.instantiations,
+		m.initializeErrors,
+		m.initializeDuration,
+		m.poolHits,
+		m.poolMisses,
+		m.resizeBytes,
+		m.nextCalls,
+		m.recycles,
+	}
+}
+
+// Describe implements prom.Collector
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prom.Collector
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// Run implements scale.Metrics
+func (m *Metrics) Run(function string, duration time.Duration, err error) {
+	m.invocations.WithLabelValues(function).Inc()
+	m.runDuration.WithLabelValues(function).Observe(duration.Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(function).Inc()
+	}
+}
+
+// Initialize implements scale.Metrics
+func (m *Metrics) Initialize(function string, duration time.Duration, err error) {
+	m.instantiations.WithLabelValues(function).Inc()
+	m.initializeDuration.WithLabelValues(function).Observe(duration.Seconds())
+	if err != nil {
+		m.initializeErrors.WithLabelValues(function).Inc()
+	}
+}
+
+// PoolHit implements scale.Metrics
+func (m *Metrics) PoolHit(function string) {
+	m.poolHits.WithLabelValues(function).Inc()
+}
+
+// PoolMiss implements scale.Metrics
+func (m *Metrics) PoolMiss(function string) {
+	m.poolMisses.WithLabelValues(function).Inc()
+}
+
+// Resize implements scale.Metrics
+func (m *Metrics) Resize(function string, bytes int) {
+	m.resizeBytes.WithLabelValues(function).Add(float64(bytes))
+}
+
+// Next implements scale.Metrics
+func (m *Metrics) Next(function string) {
+	m.nextCalls.WithLabelValues(function).Inc()
+}
+
+// Recycle implements scale.Metrics
+func (m *Metrics) Recycle(function string) {
+	m.recycles.WithLabelValues(function).Inc()
+}
@@ -0,0 +1,65 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package prometheus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := New("")
+
+	registry := prom.NewRegistry()
+	require.NoError(t, registry.Register(m))
+
+	m.Run("example:latest", time.Millisecond, nil)
+	m.Run("example:latest", time.Millisecond, errors.New("test"))
+	m.Initialize("example:latest", time.Millisecond, nil)
+	m.PoolHit("example:latest")
+	m.PoolMiss("example:latest")
+	m.Resize("example:latest", 32)
+	m.Resize("example:latest", 16)
+	m.Next("example:latest")
+	m.Recycle("example:latest")
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.invocations.WithLabelValues("example:latest")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.errors.WithLabelValues("example:latest")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.instantiations.WithLabelValues("example:latest")))
+	assert.Equal(t, 0.0, testutil.ToFloat64(m.initializeErrors.WithLabelValues("example:latest")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.poolHits.WithLabelValues("example:latest")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.poolMisses.WithLabelValues("example:latest")))
+	assert.Equal(t, 48.0, testutil.ToFloat64(m.resizeBytes.WithLabelValues("example:latest")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.nextCalls.WithLabelValues("example:latest")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.recycles.WithLabelValues("example:latest")))
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP scale_next_calls_total Number of calls to the next host function
+# TYPE scale_next_calls_total counter
+scale_next_calls_total{function="example:latest"} 1
+`), "scale_next_calls_total")
+	assert.NoError(t, err)
+}
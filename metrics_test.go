@@ -0,0 +1,152 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Metrics = (*testMetrics)(nil)
+
+// testMetrics records every measurement it receives, by function
+type testMetrics struct {
+	mu          sync.Mutex
+	runs        map[string][]error
+	durations   map[string][]time.Duration
+	initialized map[string]int
+	hits        map[string]int
+	misses      map[string]int
+	resized     map[string]int
+	next        map[string]int
+	recycled    map[string]int
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{
+		runs:        make(map[string][]error),
+		durations:   make(map[string][]time.Duration),
+		initialized: make(map[string]int),
+		hits:        make(map[string]int),
+		misses:      make(map[string]int),
+		resized:     make(map[string]int),
+		next:        make(map[string]int),
+		recycled:    make(map[string]int),
+	}
+}
+
+func (m *testMetrics) Run(function string, duration time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs[function] = append(m.runs[function], err)
+	m.durations[function] = append(m.durations[function], duration)
+}
+
+func (m *testMetrics) Initialize(function string, _ time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == nil {
+		m.initialized[function]++
+	}
+}
+
+func (m *testMetrics) PoolHit(function string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hits[function]++
+}
+
+func (m *testMetrics) PoolMiss(function string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.misses[function]++
+}
+
+func (m *testMetrics) Resize(function string, bytes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.resized[function] += bytes
+}
+
+func (m *testMetrics) Next(function string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next[function]++
+}
+
+func (m *testMetrics) Recycle(function string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recycled[function]++
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := newTestMetrics()
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", true), NewFunctionConfig().WithMaxModules(1)).
+		WithFunction(testGuest(t, "second", false)).
+		WithMetrics(metrics))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	for _, input := range []string{"n", "echo"} {
+		_, err = runTestInstance(t, instance, input)
+		require.NoError(t, err)
+	}
+	_, err = runTestInstance(t, instance, "t")
+	require.Error(t, err)
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	// Only the first function runs unless `next` is called
+	require.Len(t, metrics.runs["first:latest"], 3)
+	assert.NoError(t, metrics.runs["first:latest"][0])
+	assert.NoError(t, metrics.runs["first:latest"][1])
+	assert.ErrorContains(t, metrics.runs["first:latest"][2], "unreachable")
+	assert.Equal(t, []error{nil}, metrics.runs["second:latest"])
+	for _, durations := range metrics.durations {
+		for _, duration := range durations {
+			assert.Greater(t, duration, time.Duration(0))
+		}
+	}
+
+	assert.Equal(t, 1, metrics.next["first:latest"])
+	assert.Equal(t, 1, metrics.next["second:latest"])
+	// The output of `next` is written back into the calling function
+	assert.Equal(t, 2*len("n")+len("echo")+len("t"), metrics.resized["first:latest"])
+	assert.Equal(t, 2*len("n"), metrics.resized["second:latest"])
+
+	// The module of the first function is reused until it traps
+	assert.Equal(t, 1, metrics.misses["first:latest"])
+	assert.Equal(t, 2, metrics.hits["first:latest"])
+	assert.Equal(t, 1, metrics.recycled["first:latest"])
+	assert.Equal(t, 1, metrics.initialized["first:latest"])
+	assert.Equal(t, 1, metrics.initialized["second:latest"])
+
+	stats := r.PoolStats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, uint64(metrics.misses["first:latest"]), stats[0].Misses)
+	assert.Equal(t, uint64(metrics.hits["first:latest"]), stats[0].Hits)
+}
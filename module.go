@@ -18,8 +18,11 @@ package scale
 
 import (
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"runtime"
+	"time"
 
 	"github.com/loopholelabs/scale/log"
 
@@ -50,22 +53,43 @@ type module[T interfaces.Signature] struct {
 
 	// signature is set during the initialization of the module
 	signature T
+
+	// errors is the number of consecutive failed runs of the module
+	errors uint32
+
+	// poisoned is the error that caused the module to be marked for recycling, if any
+	poisoned error
+
+	// loggers are the structured loggers for the module's output, if any
+	loggers []*log.Logger
 }
 
 // newModule creates a new module
-func newModule[T interfaces.Signature](ctx context.Context, template *template[T]) (*module[T], error) {
+func newModule[T interfaces.Signature](ctx context.Context, template *template[T]) (_ *module[T], err error) {
+	start := time.Now()
+	defer func() {
+		template.runtime.config.metrics.Initialize(template.identifier, time.Since(start), err)
+	}()
+
 	name := fmt.Sprintf("%s.%s", template.identifier, uuid.New().String())
 	config := template.runtime.moduleConfig.WithName(name)
-	for k, v := range template.env {
-		config = config.WithEnv(k, v)
-	}
+	config = template.config.moduleConfig(config)
 
-	if template.runtime.config.stdout != nil && !template.runtime.config.rawOutput {
-		config = config.WithStdout(log.NewNamedLogger(name, template.runtime.config.stdout))
+	var loggers []*log.Logger
+	stdout, logger := template.output(name, log.Stdout)
+	if stdout != nil {
+		config = config.WithStdout(stdout)
+	}
+	if logger != nil {
+		loggers = append(loggers, logger)
 	}
 
-	if template.runtime.config.stderr != nil && !template.runtime.config.rawOutput {
-		config = config.WithStderr(log.NewNamedLogger(name, template.runtime.config.stderr))
+	stderr, logger := template.output(name, log.Stderr)
+	if stderr != nil {
+		config = config.WithStderr(stderr)
+	}
+	if logger != nil {
+		loggers = append(loggers, logger)
 	}
 
 	instantiatedModule, err := template.runtime.runtime.InstantiateModule(ctx, template.compiled, config)
@@ -106,15 +130,39 @@ func newModule[T interfaces.Signature](ctx context.Context, template *template[T
 		instantiatedModule: instantiatedModule,
 		runFunction:        run,
 		resizeFunction:     resize,
+		loggers:            loggers,
 	}, nil
 }
 
 // run runs the module
 //
 // The signature of the module must be set before calling this function
-func (m *module[T]) run(ctx context.Context) error {
+func (m *module[T]) run(ctx context.Context) (err error) {
+	ctx, span := m.template.runtime.startSpan(ctx, m)
+	start := time.Now()
+	defer func() {
+		for _, logger := range m.loggers {
+			logger.Flush()
+		}
+		m.template.runtime.config.metrics.Run(m.template.identifier, time.Since(start), err)
+		endSpan(span, err)
+	}()
+
+	runCtx := ctx
+	limit := m.template.runtime.config.executionLimit
+	if limit > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, limit)
+		defer cancel()
+	}
+
 	buf := m.signature.Write()
-	writeBuffer, err := m.resizeFunction.Call(ctx, uint64(len(buf)))
+	err = m.checkSignatureSize(len(buf))
+	if err != nil {
+		return err
+	}
+
+	writeBuffer, err := m.resizeFunction.Call(runCtx, uint64(len(buf)))
 	if err != nil {
 		return fmt.Errorf("failed to allocate memory for function '%s': %w", m.template.identifier, err)
 	}
@@ -122,13 +170,28 @@ func (m *module[T]) run(ctx context.Context) error {
 	if !m.instantiatedModule.Memory().Write(uint32(writeBuffer[0]), buf) {
 		return fmt.Errorf("failed to write memory for function '%s'", m.template.identifier)
 	}
+	m.template.runtime.config.metrics.Resize(m.template.identifier, len(buf))
 
-	packed, err := m.runFunction.Call(ctx)
+	packed, err := m.runFunction.Call(runCtx)
 	if err != nil {
-		return fmt.Errorf("failed to run function '%s': %w", m.template.identifier, err)
+		// Only report the execution limit if it was this call's deadline that expired,
+		// and not a deadline or cancellation coming from the caller's context
+		if limit > 0 && ctx.Err() == nil && errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w: function '%s' did not complete within %s", ErrExecutionLimitExceeded, m.template.identifier, limit)
+		}
+		// A cancelled call closes the module it was running on, but
+		// does not affect any other call or module of the runtime
+		if ctx.Err() != nil && m.closed() {
+			return fmt.Errorf("function '%s' was interrupted: %w", m.template.identifier, ctx.Err())
+		}
+		guestErr := newGuestError(m, err)
+		if m.memoryExhausted() {
+			return fmt.Errorf("%w: function '%s' failed after reaching its limit of %d memory pages: %w", ErrMemoryLimitExceeded, m.template.identifier, m.template.config.maxMemoryPages, guestErr)
+		}
+		return guestErr
 	}
 	if packed[0] == 0 {
-		return fmt.Errorf("failed to run function '%s'", m.template.identifier)
+		return newGuestError(m, nil)
 	}
 
 	ptr, length := unpackUint32(packed[0])
@@ -147,6 +210,9 @@ func (m *module[T]) run(ctx context.Context) error {
 // register sets the module's instance field and registers it as an active module with the runtime
 func (m *module[T]) register(function *function[T]) {
 	m.function = function
+	for _, logger := range m.loggers {
+		logger.SetInstance(hex.EncodeToString(function.instance.identifier))
+	}
 	m.template.runtime.activeModulesMu.Lock()
 	m.template.runtime.activeModules[m.instantiatedModule.Name()] = m
 	m.template.runtime.activeModulesMu.Unlock()
@@ -155,12 +221,42 @@ func (m *module[T]) register(function *function[T]) {
 // cleanup removes the module from the runtime's active modules map
 func (m *module[T]) cleanup() {
 	m.function = nil
+	for _, logger := range m.loggers {
+		logger.SetInstance("")
+	}
 	m.template.runtime.activeModulesMu.Lock()
 	// m.instantiatedModule.CloseWithExitCode(m.template.runtime.config.context, 0)
 	delete(m.template.runtime.activeModules, m.instantiatedModule.Name())
 	m.template.runtime.activeModulesMu.Unlock()
 }
 
+// closed returns true if the underlying wasm module has been closed
+//
+// This happens when a call is aborted (for example because it exceeded the
+// configured execution limit), in which case the module can no longer be used
+func (m *module[T]) closed() bool {
+	return m.instantiatedModule.IsClosed()
+}
+
+// checkSignatureSize returns an error if an encoded signature of the given size
+// exceeds the configured signature size limit for the module's function
+func (m *module[T]) checkSignatureSize(size int) error {
+	if limit := m.template.config.maxSignatureSize; limit > 0 && uint64(size) > uint64(limit) {
+		return fmt.Errorf("%w: signature for function '%s' is %d bytes, limit is %d bytes", ErrSignatureSizeExceeded, m.template.identifier, size, limit)
+	}
+	return nil
+}
+
+// memoryExhausted returns true if the module has a memory limit configured
+// and its linear memory cannot grow any further
+func (m *module[T]) memoryExhausted() bool {
+	limit := m.template.config.maxMemoryPages
+	if limit == 0 {
+		return false
+	}
+	return m.instantiatedModule.Memory().Size()/memoryPageSize >= limit
+}
+
 // setSignature sets the module's signature
 func (m *module[T]) setSignature(signature T) {
 	m.signature = signature
@@ -0,0 +1,179 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"bytes"
+	"strconv"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// The types in this file implement the subset of the OTLP JSON encoding
+// (https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding) that
+// is required to decode the trace data sent by guests through the `otel_trace_json` host function
+
+const (
+	otlpStatusCodeOk    = 1
+	otlpStatusCodeError = 2
+)
+
+type otlpTraceData struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano otlpInt64      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   otlpInt64      `json:"endTimeUnixNano"`
+	Attributes        otlpAttributes `json:"attributes"`
+	Events            []otlpEvent    `json:"events"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano otlpInt64      `json:"timeUnixNano"`
+	Name         string         `json:"name"`
+	Attributes   otlpAttributes `json:"attributes"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type otlpAttributes []otlpKeyValue
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue"`
+	BoolValue   *bool           `json:"boolValue"`
+	IntValue    *otlpInt64      `json:"intValue"`
+	DoubleValue *float64        `json:"doubleValue"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue"`
+}
+
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+// otlpInt64 is a 64-bit integer that, as per the OTLP JSON encoding,
+// can be encoded either as a JSON number or as a decimal string
+type otlpInt64 int64
+
+func (i *otlpInt64) UnmarshalJSON(data []byte) error {
+	data = bytes.Trim(data, `"`)
+	if len(data) == 0 {
+		*i = 0
+		return nil
+	}
+	v, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return err
+	}
+	*i = otlpInt64(v)
+	return nil
+}
+
+// keyValues converts the OTLP attributes into OpenTelemetry attributes
+//
+// Arrays are only supported if all of their values are of the same type,
+// any other values are ignored
+func (a otlpAttributes) keyValues() []attribute.KeyValue {
+	keyValues := make([]attribute.KeyValue, 0, len(a))
+	for _, kv := range a {
+		v := kv.Value
+		switch {
+		case v.StringValue != nil:
+			keyValues = append(keyValues, attribute.String(kv.Key, *v.StringValue))
+		case v.BoolValue != nil:
+			keyValues = append(keyValues, attribute.Bool(kv.Key, *v.BoolValue))
+		case v.IntValue != nil:
+			keyValues = append(keyValues, attribute.Int64(kv.Key, int64(*v.IntValue)))
+		case v.DoubleValue != nil:
+			keyValues = append(keyValues, attribute.Float64(kv.Key, *v.DoubleValue))
+		case v.ArrayValue != nil:
+			if array, ok := v.ArrayValue.keyValue(kv.Key); ok {
+				keyValues = append(keyValues, array)
+			}
+		}
+	}
+	return keyValues
+}
+
+func (a *otlpArrayValue) keyValue(key string) (attribute.KeyValue, bool) {
+	if len(a.Values) == 0 {
+		return attribute.KeyValue{}, false
+	}
+
+	switch first := a.Values[0]; {
+	case first.StringValue != nil:
+		values := make([]string, 0, len(a.Values))
+		for _, v := range a.Values {
+			if v.StringValue == nil {
+				return attribute.KeyValue{}, false
+			}
+			values = append(values, *v.StringValue)
+		}
+		return attribute.StringSlice(key, values), true
+	case first.BoolValue != nil:
+		values := make([]bool, 0, len(a.Values))
+		for _, v := range a.Values {
+			if v.BoolValue == nil {
+				return attribute.KeyValue{}, false
+			}
+			values = append(values, *v.BoolValue)
+		}
+		return attribute.BoolSlice(key, values), true
+	case first.IntValue != nil:
+		values := make([]int64, 0, len(a.Values))
+		for _, v := range a.Values {
+			if v.IntValue == nil {
+				return attribute.KeyValue{}, false
+			}
+			values = append(values, int64(*v.IntValue))
+		}
+		return attribute.Int64Slice(key, values), true
+	case first.DoubleValue != nil:
+		values := make([]float64, 0, len(a.Values))
+		for _, v := range a.Values {
+			if v.DoubleValue == nil {
+				return attribute.KeyValue{}, false
+			}
+			values = append(values, *v.DoubleValue)
+		}
+		return attribute.Float64Slice(key, values), true
+	}
+
+	return attribute.KeyValue{}, false
+}
@@ -18,25 +18,93 @@ package scale
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	interfaces "github.com/loopholelabs/scale-signature-interfaces"
 )
 
+var (
+	ErrPoolExhausted = errors.New("module pool exhausted")
+)
+
+// PoolStats contains the statistics for the module pool of a single stateless function
+type PoolStats struct {
+	// Function is the identifier of the function the pool belongs to
+	Function string
+
+	// Bounded is true if the pool has a maximum size
+	Bounded bool
+
+	// Idle is the number of modules currently waiting in the pool
+	//
+	// This is always 0 for unbounded pools
+	Idle uint32
+
+	// Total is the number of modules that currently exist for the pool, both idle and in use
+	//
+	// This is always 0 for unbounded pools
+	Total uint32
+
+	// Hits is the number of times a module was retrieved from the pool
+	Hits uint64
+
+	// Misses is the number of times a new module had to be instantiated
+	Misses uint64
+
+	// Rejections is the number of times a module could not be retrieved
+	// because the pool was exhausted (or the caller's context was cancelled while waiting)
+	Rejections uint64
+
+	// Evictions is the number of idle modules that were closed by the pool
+	Evictions uint64
+}
+
+type idleModule[T interfaces.Signature] struct {
+	module *module[T]
+	since  time.Time
+}
+
 type modulePool[T interfaces.Signature] struct {
+	template *template[T]
+	metrics  Metrics
+
 	pool    sync.Pool
 	maxSize uint32
 	new     func() (*module[T], error)
 	close   func(*module[T])
-	ch      chan *module[T]
+
+	// ch contains the idle modules of a bounded pool
+	ch chan idleModule[T]
+
+	// sem contains one entry for every module that exists in a bounded pool
+	sem chan struct{}
+
+	minIdle     uint32
+	block       bool
+	idleTimeout time.Duration
+
+	// done is closed when the pool is closed
+	done      chan struct{}
+	closeOnce sync.Once
+
+	hits       atomic.Uint64
+	misses     atomic.Uint64
+	rejections atomic.Uint64
+	evictions  atomic.Uint64
 }
 
-func newModulePool[T interfaces.Signature](ctx context.Context, template *template[T], maxSize uint32) *modulePool[T] {
-	if maxSize == 0 {
+func newModulePool[T interfaces.Signature](ctx context.Context, template *template[T]) (*modulePool[T], error) {
+	config := template.config
+	if config.maxModules == 0 {
 		// If size = 0, use a standard sync.Pool + golang's runtime finalizers
 		// to make sure the close function gets called eventually.
 		return &modulePool[T]{
-			maxSize: maxSize,
+			template: template,
+			metrics:  template.runtime.config.metrics,
 			new: func() (*module[T], error) {
 				m, err := newModule[T](ctx, template)
 				if m != nil {
@@ -47,24 +115,40 @@ func newModulePool[T interfaces.Signature](ctx context.Context, template *templa
 			close: func(m *module[T]) {
 				m.Close(m)
 			},
-		}
+			done: make(chan struct{}),
+		}, nil
 	}
 
-	if maxSize > 0 {
-		// if size > 0 then we use buffered channel implementation
-		return &modulePool[T]{
-			maxSize: maxSize,
-			new: func() (*module[T], error) {
-				return newModule[T](ctx, template)
-			},
-			close: func(m *module[T]) {
-				m.Close(m)
-			},
-			ch: make(chan *module[T], maxSize),
-		}
+	// if size > 0 then we use buffered channel implementation
+	p := &modulePool[T]{
+		template: template,
+		metrics:  template.runtime.config.metrics,
+		maxSize:  config.maxModules,
+		new: func() (*module[T], error) {
+			return newModule[T](ctx, template)
+		},
+		close: func(m *module[T]) {
+			m.Close(m)
+		},
+		ch:          make(chan idleModule[T], config.maxModules),
+		sem:         make(chan struct{}, config.maxModules),
+		minIdle:     config.minIdleModules,
+		block:       config.blockOnExhausted,
+		idleTimeout: config.idleTimeout,
+		done:        make(chan struct{}),
 	}
 
-	return nil
+	err := p.fill()
+	if err != nil {
+		p.drain()
+		return nil, fmt.Errorf("failed to pre-warm module pool for function '%s': %w", template.identifier, err)
+	}
+
+	if p.idleTimeout > 0 {
+		go p.evict(ctx)
+	}
+
+	return p, nil
 }
 
 func (p *modulePool[T]) Put(m *module[T]) {
@@ -72,33 +156,207 @@ func (p *modulePool[T]) Put(m *module[T]) {
 		return
 	}
 
+	select {
+	case <-p.done:
+		// The pool is closed, so the module is closed instead of being returned
+		if p.maxSize == 0 {
+			p.close(m)
+		} else {
+			p.destroy(m)
+		}
+		return
+	default:
+	}
+
 	if p.maxSize == 0 {
 		p.pool.Put(m)
 	} else {
 		select {
-		case p.ch <- m:
+		case p.ch <- idleModule[T]{module: m, since: time.Now()}:
 		default:
 			// Channel is full, call the close function
-			p.close(m)
+			p.destroy(m)
 		}
 	}
 }
 
-func (p *modulePool[T]) Get() (*module[T], error) {
+// Discard closes a module that is no longer usable instead of returning it to the pool
+func (p *modulePool[T]) Discard(m *module[T]) {
+	if m == nil {
+		return
+	}
+	if p.maxSize == 0 {
+		p.close(m)
+		return
+	}
+	p.destroy(m)
+}
+
+func (p *modulePool[T]) Get(ctx context.Context) (*module[T], error) {
 	if p.maxSize == 0 {
 		m, ok := p.pool.Get().(*module[T])
 		if ok && m != nil {
+			p.hit()
 			return m, nil
 		}
+		p.miss()
 		return p.new()
 	}
 
 	// Use buffered channel
 	select {
-	case m := <-p.ch:
-		return m, nil
+	case idle := <-p.ch:
+		p.hit()
+		return idle.module, nil
 	default:
-		m, _ := p.pool.Get().(*module[T])
-		return m, nil
+	}
+
+	select {
+	case p.sem <- struct{}{}:
+		return p.create()
+	default:
+	}
+
+	if !p.block {
+		p.rejections.Add(1)
+		return nil, fmt.Errorf("%w: function '%s' has %d modules in use", ErrPoolExhausted, p.template.identifier, p.maxSize)
+	}
+
+	select {
+	case idle := <-p.ch:
+		p.hit()
+		return idle.module, nil
+	case p.sem <- struct{}{}:
+		return p.create()
+	case <-ctx.Done():
+		p.rejections.Add(1)
+		return nil, fmt.Errorf("%w: function '%s': %w", ErrPoolExhausted, p.template.identifier, ctx.Err())
+	}
+}
+
+// Close stops the pool's background eviction and closes all of its idle modules
+//
+// Modules that are in use when the pool is closed are closed when they are returned.
+func (p *modulePool[T]) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		if p.maxSize > 0 {
+			p.drain()
+		}
+	})
+}
+
+// Stats returns the current statistics for the pool
+func (p *modulePool[T]) Stats() PoolStats {
+	return PoolStats{
+		Function:   p.template.identifier,
+		Bounded:    p.maxSize > 0,
+		Idle:       uint32(len(p.ch)),
+		Total:      uint32(len(p.sem)),
+		Hits:       p.hits.Load(),
+		Misses:     p.misses.Load(),
+		Rejections: p.rejections.Load(),
+		Evictions:  p.evictions.Load(),
+	}
+}
+
+// create instantiates a new module for a bounded pool
+//
+// The caller must have already reserved a slot in the pool's semaphore
+func (p *modulePool[T]) create() (*module[T], error) {
+	p.miss()
+	m, err := p.new()
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return m, nil
+}
+
+func (p *modulePool[T]) hit() {
+	p.hits.Add(1)
+	p.metrics.PoolHit(p.template.identifier)
+}
+
+func (p *modulePool[T]) miss() {
+	p.misses.Add(1)
+	p.metrics.PoolMiss(p.template.identifier)
+}
+
+// destroy closes a module of a bounded pool and releases its slot
+func (p *modulePool[T]) destroy(m *module[T]) {
+	p.close(m)
+	<-p.sem
+}
+
+// fill instantiates idle modules until the pool has at least minIdle idle modules,
+// or until the pool is full
+func (p *modulePool[T]) fill() error {
+	for uint32(len(p.ch)) < p.minIdle {
+		select {
+		case p.sem <- struct{}{}:
+		default:
+			return nil
+		}
+		m, err := p.new()
+		if err != nil {
+			<-p.sem
+			return err
+		}
+		p.Put(m)
+	}
+	return nil
+}
+
+// drain closes all the idle modules in the pool
+func (p *modulePool[T]) drain() {
+	for {
+		select {
+		case idle := <-p.ch:
+			p.destroy(idle.module)
+		default:
+			return
+		}
+	}
+}
+
+// evict periodically closes modules that have been idle for longer than the idle timeout,
+// while keeping at least minIdle modules around
+func (p *modulePool[T]) evict(ctx context.Context) {
+	ticker := time.NewTicker(p.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		for n := len(p.ch); n > 0; n-- {
+			var idle idleModule[T]
+			select {
+			case idle = <-p.ch:
+			default:
+			}
+			if idle.module == nil {
+				break
+			}
+
+			if time.Since(idle.since) > p.idleTimeout && uint32(len(p.ch)) >= p.minIdle {
+				p.evictions.Add(1)
+				p.destroy(idle.module)
+				continue
+			}
+
+			select {
+			case p.ch <- idle:
+			default:
+				p.destroy(idle.module)
+			}
+		}
+
+		_ = p.fill()
 	}
 }
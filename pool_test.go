@@ -0,0 +1,150 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestModulePool(t *testing.T, config *FunctionConfig) *modulePool[*testSignature] {
+	t.Helper()
+
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", true), config))
+	require.Len(t, r.templates, 1)
+	require.NotNil(t, r.templates[0].modulePool)
+	return r.templates[0].modulePool
+}
+
+func TestModulePool(t *testing.T) {
+	t.Run("PreWarm", func(t *testing.T) {
+		p := newTestModulePool(t, NewFunctionConfig().WithMaxModules(4).WithMinIdleModules(2))
+
+		stats := p.Stats()
+		assert.True(t, stats.Bounded)
+		assert.Equal(t, uint32(2), stats.Idle)
+		assert.Equal(t, uint32(2), stats.Total)
+
+		_, err := p.Get(context.Background())
+		require.NoError(t, err)
+
+		stats = p.Stats()
+		assert.Equal(t, uint64(1), stats.Hits)
+		assert.Equal(t, uint32(1), stats.Idle)
+	})
+
+	t.Run("FailFast", func(t *testing.T) {
+		p := newTestModulePool(t, NewFunctionConfig().WithMaxModules(2))
+
+		m1, err := p.Get(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, m1)
+
+		m2, err := p.Get(context.Background())
+		require.NoError(t, err)
+		require.NotNil(t, m2)
+
+		_, err = p.Get(context.Background())
+		assert.ErrorIs(t, err, ErrPoolExhausted)
+
+		p.Put(m1)
+		m3, err := p.Get(context.Background())
+		require.NoError(t, err)
+		assert.Same(t, m1, m3)
+
+		stats := p.Stats()
+		assert.Equal(t, uint64(2), stats.Misses)
+		assert.Equal(t, uint64(1), stats.Hits)
+		assert.Equal(t, uint64(1), stats.Rejections)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		p := newTestModulePool(t, NewFunctionConfig().WithMaxModules(1).WithBlockOnExhausted(true))
+
+		m, err := p.Get(context.Background())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+		defer cancel()
+		_, err = p.Get(ctx)
+		assert.ErrorIs(t, err, ErrPoolExhausted)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		go func() {
+			time.Sleep(time.Millisecond * 10)
+			p.Put(m)
+		}()
+
+		blocked, err := p.Get(context.Background())
+		require.NoError(t, err)
+		assert.Same(t, m, blocked)
+	})
+
+	t.Run("Discard", func(t *testing.T) {
+		p := newTestModulePool(t, NewFunctionConfig().WithMaxModules(1))
+
+		m, err := p.Get(context.Background())
+		require.NoError(t, err)
+
+		p.Discard(m)
+		assert.True(t, m.closed())
+		assert.Equal(t, uint32(0), p.Stats().Total)
+
+		_, err = p.Get(context.Background())
+		require.NoError(t, err)
+	})
+
+	t.Run("DiscardUnbounded", func(t *testing.T) {
+		p := newTestModulePool(t, NewFunctionConfig())
+		assert.False(t, p.Stats().Bounded)
+
+		m, err := p.Get(context.Background())
+		require.NoError(t, err)
+
+		p.Discard(m)
+		assert.True(t, m.closed())
+
+		next, err := p.Get(context.Background())
+		require.NoError(t, err)
+		assert.NotSame(t, m, next)
+		assert.False(t, next.closed())
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		p := newTestModulePool(t, NewFunctionConfig().WithMaxModules(4).WithMinIdleModules(1).WithIdleTimeout(time.Millisecond*10))
+
+		m1, err := p.Get(context.Background())
+		require.NoError(t, err)
+		m2, err := p.Get(context.Background())
+		require.NoError(t, err)
+		p.Put(m1)
+		p.Put(m2)
+
+		assert.Eventually(t, func() bool {
+			return p.Stats().Evictions == 1
+		}, time.Second, time.Millisecond*5)
+		assert.True(t, m1.closed() != m2.closed())
+		assert.Equal(t, uint32(1), p.Stats().Idle)
+	})
+}
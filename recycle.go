@@ -0,0 +1,97 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"encoding/hex"
+	"errors"
+)
+
+var (
+	errModuleClosed = errors.New("module closed")
+)
+
+// RecycleEvent is emitted when a module is discarded because it may be in a corrupted state,
+// so that the next call to its function uses a freshly instantiated module
+type RecycleEvent struct {
+	// Function is the identifier (`<name>:<tag>`) of the function the module belongs to
+	Function string
+
+	// InstanceID is the hex encoded identifier of the Instance that was using the module
+	InstanceID string
+
+	// Errors is the number of consecutive failed runs of the module
+	Errors uint32
+
+	// Reason is the error that caused the module to be recycled
+	Reason error
+}
+
+// record keeps track of the result of a run, and marks the module as poisoned
+// if it trapped, was closed, or exceeded the configured number of consecutive errors
+//
+// Errors caused by the host before the module ran do not count towards the consecutive errors.
+func (m *module[T]) record(err error) {
+	if err == nil {
+		m.errors = 0
+		return
+	}
+	if hostError(err) {
+		// The module did not run, so the error says nothing about its state
+		return
+	}
+	m.errors++
+
+	var guestErr *GuestError
+	if m.closed() || (errors.As(err, &guestErr) && guestErr.Kind != TrapKindNone) {
+		m.poisoned = err
+		return
+	}
+
+	if limit := m.template.config.recycleAfterErrors; limit > 0 && m.errors >= limit {
+		m.poisoned = err
+	}
+}
+
+// hostError returns true if the error was caused by the host before the module ran
+func hostError(err error) bool {
+	return errors.Is(err, ErrSignatureSizeExceeded)
+}
+
+// recyclable returns true if the module must not be used anymore
+func (m *module[T]) recyclable() bool {
+	return m.poisoned != nil || m.closed()
+}
+
+// recycled emits a RecycleEvent for the given module, which belonged to this function
+func (f *function[T]) recycled(m *module[T]) {
+	event := RecycleEvent{
+		Function:   f.template.identifier,
+		InstanceID: hex.EncodeToString(f.instance.identifier),
+		Errors:     m.errors,
+		Reason:     m.poisoned,
+	}
+	if event.Reason == nil {
+		event.Reason = errModuleClosed
+	}
+
+	config := f.template.runtime.config
+	config.metrics.Recycle(event.Function)
+	if config.recycleHandler != nil {
+		config.recycleHandler(event)
+	}
+}
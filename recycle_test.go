@@ -0,0 +1,145 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecycle(t *testing.T) {
+	var events []RecycleEvent
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", false), NewFunctionConfig().WithRecycleAfterErrors(2)).
+		WithRecycleHandler(func(event RecycleEvent) {
+			events = append(events, event)
+		}))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	count := func() byte {
+		output, err := runTestInstance(t, instance, "c-")
+		require.NoError(t, err)
+		return output[1]
+	}
+
+	assert.Equal(t, byte(1), count())
+	assert.Equal(t, byte(2), count())
+
+	_, err = runTestInstance(t, instance, "t")
+	require.Error(t, err)
+
+	assert.Equal(t, byte(1), count())
+	require.Len(t, events, 1)
+	assert.Equal(t, "first:latest", events[0].Function)
+	assert.Equal(t, hex.EncodeToString(instance.identifier), events[0].InstanceID)
+	assert.Equal(t, uint32(1), events[0].Errors)
+	var guestErr *GuestError
+	require.True(t, errors.As(events[0].Reason, &guestErr))
+	assert.Equal(t, TrapKindUnreachable, guestErr.Kind)
+
+	_, err = runTestInstance(t, instance, "z")
+	require.Error(t, err)
+	assert.Equal(t, byte(2), count())
+	assert.Len(t, events, 1)
+
+	for i := 0; i < 2; i++ {
+		_, err = runTestInstance(t, instance, "z")
+		require.Error(t, err)
+	}
+	assert.Equal(t, byte(1), count())
+	require.Len(t, events, 2)
+	assert.Equal(t, uint32(2), events[1].Errors)
+}
+
+func TestRecyclePool(t *testing.T) {
+	var events []RecycleEvent
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", true), NewFunctionConfig().WithMaxModules(1)).
+		WithRecycleHandler(func(event RecycleEvent) {
+			events = append(events, event)
+		}))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	_, err = runTestInstance(t, instance, "c-")
+	require.NoError(t, err)
+
+	_, err = runTestInstance(t, instance, "o")
+	require.Error(t, err)
+	require.Len(t, events, 1)
+
+	output, err := runTestInstance(t, instance, "c-")
+	require.NoError(t, err)
+	assert.Equal(t, byte(1), output[1])
+	assert.Equal(t, uint32(1), r.PoolStats()[0].Total)
+}
+
+func TestRecycleClosesModules(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	modules := len(r.activeModules)
+	var recycled []string
+	for i := 0; i < 32; i++ {
+		recycled = append(recycled, instance.head.module.instantiatedModule.Name())
+		_, err = runTestInstance(t, instance, "t")
+		require.Error(t, err)
+		_, err = runTestInstance(t, instance, "c-")
+		require.NoError(t, err)
+	}
+
+	assert.Len(t, r.activeModules, modules)
+	for _, name := range recycled {
+		assert.Nil(t, r.runtime.Module(name), "module %s was not closed", name)
+	}
+}
+
+func TestRecycleHostErrors(t *testing.T) {
+	var events []RecycleEvent
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", false), NewFunctionConfig().WithRecycleAfterErrors(1).WithMaxSignatureSize(8)).
+		WithRecycleHandler(func(event RecycleEvent) {
+			events = append(events, event)
+		}))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	output, err := runTestInstance(t, instance, "c-")
+	require.NoError(t, err)
+	assert.Equal(t, byte(1), output[1])
+
+	_, err = runTestInstance(t, instance, "c-too-large")
+	require.ErrorIs(t, err, ErrSignatureSizeExceeded)
+
+	output, err = runTestInstance(t, instance, "c-")
+	require.NoError(t, err)
+	assert.Equal(t, byte(2), output[1])
+	assert.Empty(t, events)
+}
@@ -0,0 +1,380 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	extension "github.com/loopholelabs/scale-extension-interfaces"
+	"github.com/tetratelabs/wazero/api"
+)
+
+var (
+	ErrInvalidRecording = errors.New("invalid recording")
+)
+
+// CallKind is the kind of host call in a Recording
+type CallKind string
+
+const (
+	// NextCall is a call to the `next` host function
+	NextCall CallKind = "next"
+
+	// ExtensionCall is a call to a host function provided by an extension
+	ExtensionCall CallKind = "extension"
+)
+
+// Recording contains everything that crossed the boundary between the host and the
+// guest functions during a single call to Instance.Run, and can be re-executed using Scale.Replay
+type Recording struct {
+	// Function is the identifier of the first function in the chain
+	Function string `json:"function"`
+
+	// Input is the encoded signature that was passed to Instance.Run
+	Input []byte `json:"input"`
+
+	// Output is the encoded signature after Instance.Run completed, if it did not return an error
+	Output []byte `json:"output,omitempty"`
+
+	// Error is the error returned by Instance.Run, if any
+	Error string `json:"error,omitempty"`
+
+	// Calls are the host calls made by the functions, in the order they were made
+	Calls []RecordedCall `json:"calls"`
+}
+
+// RecordedCall is a single host call made by a function
+type RecordedCall struct {
+	// Function is the identifier of the function that made the call
+	Function string `json:"function"`
+
+	// Kind is the kind of the call
+	Kind CallKind `json:"kind"`
+
+	// Name is the name of the extension host function, for extension calls
+	Name string `json:"name,omitempty"`
+
+	// Request is the buffer the function passed to the host
+	Request []byte `json:"request"`
+
+	// Response is the encoded signature returned to the function, for `next` calls
+	Response []byte `json:"response,omitempty"`
+
+	// Params are the raw parameters of the call, and Result is its raw result, for extension calls
+	Params []uint64 `json:"params,omitempty"`
+	Result uint64   `json:"result,omitempty"`
+
+	// Buffers are the buffers the extension wrote into the function's memory, for extension calls
+	Buffers []RecordedBuffer `json:"buffers,omitempty"`
+}
+
+// RecordedBuffer is a buffer that an extension wrote into a function's memory
+type RecordedBuffer struct {
+	// Resize is the name of the export the extension used to allocate the buffer
+	Resize string `json:"resize"`
+
+	// Data is the contents of the buffer after the extension returned
+	Data []byte `json:"data"`
+}
+
+// ReplayResult is the result of replaying a Recording
+type ReplayResult struct {
+	// Output and Error are the encoded signature and error produced by the replay
+	Output []byte
+	Error  string
+
+	// ExpectedOutput and ExpectedError are the encoded signature and error in the recording
+	ExpectedOutput []byte
+	ExpectedError  string
+
+	// Divergences describe the host calls made during the replay that did not match the recording
+	Divergences []string
+}
+
+// Diff describes the differences between the replay and the recording,
+// and returns an empty string if the replay matched the recording
+func (r *ReplayResult) Diff() string {
+	var diff []string
+	if r.Error != r.ExpectedError {
+		diff = append(diff, fmt.Sprintf("error: expected %q, got %q", r.ExpectedError, r.Error))
+	}
+	if !bytes.Equal(r.Output, r.ExpectedOutput) {
+		offset := 0
+		for offset < len(r.Output) && offset < len(r.ExpectedOutput) && r.Output[offset] == r.ExpectedOutput[offset] {
+			offset++
+		}
+		diff = append(diff, fmt.Sprintf("output: expected %d bytes, got %d bytes, first difference at offset %d", len(r.ExpectedOutput), len(r.Output), offset))
+	}
+	for _, divergence := range r.Divergences {
+		diff = append(diff, "call: "+divergence)
+	}
+	return strings.Join(diff, "\n")
+}
+
+// SetRecorder enables recording for the instance, with the given recorder being called with a
+// Recording after every call to Run. A nil recorder disables recording.
+//
+// The function chain must be deterministic for a recording to be replayed faithfully. The recorder can be
+// changed while the instance is running, and calls that already started use the previous recorder.
+// Scale.ReleaseInstance clears the recorder, so that the next user of a warm instance does not receive its recordings.
+func (i *Instance[T]) SetRecorder(recorder func(recording *Recording)) {
+	if recorder == nil {
+		i.recorder.Store(nil)
+		return
+	}
+	i.recorder.Store(&recorder)
+}
+
+// loadRecorder returns the recorder of the instance, or nil if recording is disabled
+func (i *Instance[T]) loadRecorder() func(*Recording) {
+	if recorder := i.recorder.Load(); recorder != nil {
+		return *recorder
+	}
+	return nil
+}
+
+// startRecording returns a new recording for a run with the given input
+func (i *Instance[T]) startRecording(input []byte) *Recording {
+	recording := &Recording{
+		Function: i.head.template.identifier,
+		Input:    append([]byte{}, input...),
+		Calls:    []RecordedCall{},
+	}
+	i.recordingMu.Lock()
+	i.recording = recording
+	i.recordingMu.Unlock()
+	return recording
+}
+
+// finishRecording completes the recording with the result of the run and passes it to the given recorder
+func (i *Instance[T]) finishRecording(recorder func(*Recording), recording *Recording, output []byte, err error) {
+	if recording == nil {
+		return
+	}
+	i.recordingMu.Lock()
+	i.recording = nil
+	if err != nil {
+		recording.Error = err.Error()
+	} else {
+		recording.Output = append([]byte{}, output...)
+	}
+	i.recordingMu.Unlock()
+	recorder(recording)
+}
+
+// recordCall adds a host call to the current recording, and returns its index
+// in the recording, or -1 if there is no recording
+func (i *Instance[T]) recordCall(call RecordedCall) int {
+	i.recordingMu.Lock()
+	defer i.recordingMu.Unlock()
+	if i.recording == nil {
+		return -1
+	}
+	i.recording.Calls = append(i.recording.Calls, call)
+	return len(i.recording.Calls) - 1
+}
+
+// recordResponse sets the response of a `next` call that was added to the current recording,
+// which happens after the call is added so that the calls made by later functions are recorded after it
+func (i *Instance[T]) recordResponse(index int, response []byte) {
+	i.recordingMu.Lock()
+	defer i.recordingMu.Unlock()
+	if i.recording != nil && index >= 0 && index < len(i.recording.Calls) {
+		i.recording.Calls[index].Response = append([]byte{}, response...)
+	}
+}
+
+// recordingCalls returns true if host calls are currently being recorded
+func (i *Instance[T]) recordingCalls() bool {
+	i.recordingMu.Lock()
+	defer i.recordingMu.Unlock()
+	return i.recording != nil
+}
+
+// Replay re-executes the first function in the chain against a Recording, and returns the result
+// along with the expected result from the recording (see ReplayResult.Diff).
+//
+// Host calls are not executed during a replay, and instead the recorded responses are returned to the function.
+// The runtime must be configured with the same extensions as the one the recording was made with.
+func (r *Scale[T]) Replay(ctx context.Context, recording *Recording) (*ReplayResult, error) {
+	if recording == nil {
+		return nil, ErrInvalidRecording
+	}
+
+	instance, err := r.Instance()
+	if err != nil {
+		return nil, err
+	}
+	defer instance.Close()
+
+	if instance.head.branches != nil {
+		return nil, fmt.Errorf("%w: cannot replay a fan-out", ErrInvalidRecording)
+	}
+
+	replay := &replayer{}
+	for _, call := range recording.Calls {
+		if call.Function == recording.Function {
+			replay.calls = append(replay.calls, call)
+		}
+	}
+	instance.replay = replay
+
+	signature := r.config.newSignature()
+	err = signature.Read(recording.Input)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to decode input: %w", ErrInvalidRecording, err)
+	}
+
+	result := &ReplayResult{
+		ExpectedOutput: recording.Output,
+		ExpectedError:  recording.Error,
+	}
+
+	err = instance.Run(ctx, signature)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Output = signature.Write()
+	}
+
+	result.Divergences = replay.divergences
+	if remaining := len(replay.calls) - replay.index; remaining > 0 {
+		result.Divergences = append(result.Divergences, fmt.Sprintf("%d recorded calls were not made", remaining))
+	}
+
+	return result, nil
+}
+
+// replayer returns the recorded responses to the host calls made during a replay
+type replayer struct {
+	mu          sync.Mutex
+	calls       []RecordedCall
+	index       int
+	divergences []string
+}
+
+// call returns the next recorded call, if it matches the given kind and name
+func (r *replayer) call(kind CallKind, name string, request []byte) (RecordedCall, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.index >= len(r.calls) {
+		r.divergences = append(r.divergences, fmt.Sprintf("unexpected %s call %d", kind, r.index))
+		return RecordedCall{}, false
+	}
+
+	call := r.calls[r.index]
+	if call.Kind != kind || call.Name != name {
+		r.divergences = append(r.divergences, fmt.Sprintf("expected %s call %d to be %s %s, got %s %s", kind, r.index, call.Kind, call.Name, kind, name))
+		return RecordedCall{}, false
+	}
+	r.index++
+
+	if !bytes.Equal(call.Request, request) {
+		r.divergences = append(r.divergences, fmt.Sprintf("%s call %d has a different request", kind, r.index-1))
+	}
+	return call, true
+}
+
+// extensionFunction returns the host function for the given extension function,
+// which records or replays the calls made to it
+func (r *Scale[T]) extensionFunction(name string, f extension.InstallableFunc) api.GoModuleFunc {
+	return func(ctx context.Context, mod api.Module, params []uint64) {
+		r.activeModulesMu.RLock()
+		m := r.activeModules[mod.Name()]
+		r.activeModulesMu.RUnlock()
+
+		var instance *Instance[T]
+		if m != nil && m.function != nil {
+			instance = m.function.instance
+		}
+
+		mem := &extensionMemory{Memory: mod.Memory(), scope: scope(ctx), module: mod.Name()}
+		if instance != nil {
+			mem.instanceID = hex.EncodeToString(instance.identifier)
+		}
+
+		var request []byte
+		if instance != nil && (instance.replay != nil || instance.recordingCalls()) {
+			buf, _ := mem.Read(uint32(params[1]), uint32(params[2]))
+			request = append([]byte{}, buf...)
+		}
+
+		if instance != nil && instance.replay != nil {
+			call, ok := instance.replay.call(ExtensionCall, name, request)
+			if !ok {
+				return
+			}
+			for _, buffer := range call.Buffers {
+				ptr, err := mod.ExportedFunction(buffer.Resize).Call(ctx, uint64(len(buffer.Data)))
+				if err != nil || !mem.Write(uint32(ptr[0]), buffer.Data) {
+					return
+				}
+			}
+			params[0] = call.Result
+			return
+		}
+
+		type allocation struct {
+			resize string
+			ptr    uint64
+			size   uint64
+		}
+		var allocations []allocation
+
+		resize := func(resizeName string, size uint64) (uint64, error) {
+			w, err := mod.ExportedFunction(resizeName).Call(context.Background(), size)
+			if err != nil {
+				return 0, err
+			}
+			if m != nil {
+				r.config.metrics.Resize(m.template.identifier, int(size))
+			}
+			allocations = append(allocations, allocation{resize: resizeName, ptr: w[0], size: size})
+			return w[0], nil
+		}
+
+		var input []uint64
+		if request != nil {
+			input = append([]uint64{}, params...)
+		}
+
+		f(mem, resize, params)
+
+		if request != nil {
+			call := RecordedCall{
+				Function: m.template.identifier,
+				Kind:     ExtensionCall,
+				Name:     name,
+				Request:  request,
+				Params:   input,
+				Result:   params[0],
+			}
+			for _, a := range allocations {
+				buf, _ := mem.Read(uint32(a.ptr), uint32(a.size))
+				call.Buffers = append(call.Buffers, RecordedBuffer{Resize: a.resize, Data: append([]byte{}, buf...)})
+			}
+			_ = instance.recordCall(call)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplay(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithFunction(testGuest(t, "second", false)))
+
+	instance, err := r.Instance(func(sig *testSignature) (*testSignature, error) {
+		sig.data = append(sig.data, '!')
+		return sig, nil
+	})
+	require.NoError(t, err)
+
+	var recordings []*Recording
+	instance.SetRecorder(func(recording *Recording) {
+		recordings = append(recordings, recording)
+	})
+
+	output, err := runTestInstance(t, instance, "nn")
+	require.NoError(t, err)
+	assert.Equal(t, "nn!", output)
+
+	_, err = runTestInstance(t, instance, "t")
+	require.Error(t, err)
+
+	require.Len(t, recordings, 2)
+	recording := recordings[0]
+	assert.Equal(t, "first:latest", recording.Function)
+	assert.Equal(t, []byte("nn"), recording.Input)
+	assert.Equal(t, []byte("nn!"), recording.Output)
+	assert.Empty(t, recording.Error)
+	require.Len(t, recording.Calls, 2)
+	assert.Equal(t, RecordedCall{Function: "first:latest", Kind: NextCall, Request: []byte("nn"), Response: []byte("nn!")}, recording.Calls[0])
+	assert.Equal(t, RecordedCall{Function: "second:latest", Kind: NextCall, Request: []byte("nn"), Response: []byte("nn!")}, recording.Calls[1])
+	assert.Equal(t, err.Error(), recordings[1].Error)
+
+	encoded, err := json.Marshal(recording)
+	require.NoError(t, err)
+	decoded := new(Recording)
+	require.NoError(t, json.Unmarshal(encoded, decoded))
+	assert.Equal(t, recording, decoded)
+
+	activeModules := func() int {
+		r.activeModulesMu.RLock()
+		defer r.activeModulesMu.RUnlock()
+		return len(r.activeModules)
+	}
+	active := activeModules()
+
+	// Only the first function runs during a replay, and gets the recorded response from `next`
+	result, err := r.Replay(context.Background(), decoded)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("nn!"), result.Output)
+	assert.Empty(t, result.Diff())
+
+	result, err = r.Replay(context.Background(), recordings[1])
+	require.NoError(t, err)
+	assert.Empty(t, result.Diff())
+
+	decoded.Input = []byte("echo")
+	result, err = r.Replay(context.Background(), decoded)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("echo"), result.Output)
+	assert.Equal(t, "output: expected 3 bytes, got 4 bytes, first difference at offset 0\ncall: 1 recorded calls were not made", result.Diff())
+
+	decoded.Input = []byte("nx")
+	result, err = r.Replay(context.Background(), decoded)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("nn!"), result.Output)
+	assert.Equal(t, []string{"next call 0 has a different request"}, result.Divergences)
+
+	_, err = r.Replay(context.Background(), nil)
+	assert.ErrorIs(t, err, ErrInvalidRecording)
+
+	// The stateful modules of the instances created for the replays are closed afterwards
+	assert.Equal(t, active, activeModules())
+
+	require.NoError(t, r.Close(context.Background()))
+}
+
+func TestSetRecorder(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithWarmInstances(1))
+
+	instance, err := r.AcquireInstance(context.Background())
+	require.NoError(t, err)
+
+	// The recorder can be changed while calls are running
+	var recordings atomic.Int32
+	results := make([]<-chan Result[*testSignature], 0, 8)
+	for i := 0; i < 8; i++ {
+		results = append(results, instance.RunAsync(context.Background(), newTestSignatures("echo")[0]))
+		instance.SetRecorder(func(*Recording) {
+			recordings.Add(1)
+		})
+	}
+	for _, result := range results {
+		require.NoError(t, (<-result).Err)
+	}
+
+	// Released instances do not keep the recorder of their previous user
+	r.ReleaseInstance(instance)
+	assert.Nil(t, instance.loadRecorder())
+
+	require.NoError(t, r.Close(context.Background()))
+}
@@ -0,0 +1,126 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	interfaces "github.com/loopholelabs/scale-signature-interfaces"
+)
+
+var (
+	ErrRouteCycle = errors.New("route cycle")
+)
+
+// RouteEnd can be returned by a Router to skip the rest of the chain and continue
+// with the Next function of the Instance, as if the calling function were the last one.
+//
+// It is never a valid function name, so it cannot collide with a function in the chain.
+const RouteEnd = "<end>"
+
+// Router picks the function that runs when the function with the identifier from calls `next`.
+//
+// The returned function can be referenced either as `<name>` or `<name>:<tag>`, and returning
+// an empty string continues with the next function in the chain (or the Next function of the
+// Instance at the end of the chain). Returning RouteEnd goes to the end of the chain directly.
+type Router[T interfaces.Signature] func(sig T, from string) (to string, err error)
+
+// activeFunctionsKey is the context key for the functions that are running in the current call
+type activeFunctionsKey struct{}
+
+// activeFunction is a function that is running in the current call, along with the function that called it
+type activeFunction[T interfaces.Signature] struct {
+	function *function[T]
+	caller   *activeFunction[T]
+}
+
+// withActive returns a context in which the given function is running, if the runtime has a router
+func (f *function[T]) withActive(ctx context.Context) context.Context {
+	if f.template.runtime.config.router == nil {
+		return ctx
+	}
+	caller, _ := ctx.Value(activeFunctionsKey{}).(*activeFunction[T])
+	return context.WithValue(ctx, activeFunctionsKey{}, &activeFunction[T]{function: f, caller: caller})
+}
+
+// active returns true if the function is already running in the current call
+func (f *function[T]) active(ctx context.Context) bool {
+	a, _ := ctx.Value(activeFunctionsKey{}).(*activeFunction[T])
+	for ; a != nil; a = a.caller {
+		if a.function == f {
+			return true
+		}
+	}
+	return false
+}
+
+// function returns the first function in the chain matching the given name
+// (either `<name>` or `<name>:<tag>`), or nil if there is none
+func (i *Instance[T]) function(name string) *function[T] {
+	for fn := i.head; fn != nil; fn = fn.next {
+		if fn.template.identifier == name || (fn.template.name != "" && fn.template.name == name) {
+			return fn
+		}
+	}
+	return nil
+}
+
+// route returns the function that should run after this one, which is the next function
+// in the chain unless the runtime's router picks a different one
+//
+// A nil function means the end of the chain has been reached
+func (f *function[T]) route(signature T) (*function[T], error) {
+	router := f.template.runtime.config.router
+	if router == nil {
+		return f.next, nil
+	}
+
+	to, err := router(signature, f.template.identifier)
+	if err != nil {
+		return nil, fmt.Errorf("failed to route from function '%s': %w", f.template.identifier, err)
+	}
+	switch to {
+	case "":
+		return f.next, nil
+	case RouteEnd:
+		return nil, nil
+	}
+
+	next := f.instance.function(to)
+	if next == nil {
+		return nil, fmt.Errorf("%w: %s", ErrFunctionNotFound, to)
+	}
+	return next, nil
+}
+
+// runNext runs the function that comes after this one with the given signature,
+// or the Next function of the Instance if there is none
+func (f *function[T]) runNext(ctx context.Context, signature T) (T, error) {
+	next, err := f.route(signature)
+	if err != nil {
+		return signature, err
+	}
+	if next == nil {
+		return f.instance.next(signature)
+	}
+	if next.active(ctx) {
+		return signature, fmt.Errorf("%w: function '%s' cannot run '%s', which is already running", ErrRouteCycle, f.template.identifier, next.template.identifier)
+	}
+	return signature, next.run(ctx, signature)
+}
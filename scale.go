@@ -20,16 +20,31 @@ package scale
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	interfaces "github.com/loopholelabs/scale-signature-interfaces"
 
 	"github.com/tetratelabs/wazero"
 	"github.com/tetratelabs/wazero/api"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"go.opentelemetry.io/otel/trace"
 
-	extension "github.com/loopholelabs/scale-extension-interfaces"
+	"github.com/loopholelabs/scale/scalefunc"
+	"github.com/loopholelabs/scale/version"
+)
+
+var (
+	ErrFunctionNotFound = errors.New("function not found")
+	ErrInvalidIndex     = errors.New("invalid function index")
+	ErrClosed           = errors.New("scale runtime is closed")
 )
 
 // Next is the next function in the middleware chain. It's meant to be implemented
@@ -42,22 +57,55 @@ type Scale[T interfaces.Signature] struct {
 	runtime      wazero.Runtime
 	moduleConfig wazero.ModuleConfig
 
+	compilationCache    wazero.CompilationCache
+	compilationCacheDir string
+
 	config *Config[T]
 
-	head *template[T]
-	tail *template[T]
+	tracer trace.Tracer
+
+	// templatesMu protects templates, which is replaced (and never modified in place)
+	// whenever a function in the chain is replaced, inserted or removed
+	templatesMu sync.RWMutex
+	templates   []*template[T]
+
+	// generation is incremented every time templates is replaced
+	generation uint64
+
+	// retired contains the templates that were removed from the chain,
+	// but are still used by existing instances
+	retired []*template[T]
+
+	// compiled contains the compiled modules by the hash of their binary, since wazero shares
+	// the compiled code of identical binaries, which is removed once any of them is closed
+	compiledMu sync.Mutex
+	compiled   map[[sha256.Size]byte]*compiledModule
+
+	// instances is the pool of warm instances, and is nil if it is not enabled
+	instances *instancePool[T]
 
 	activeModulesMu sync.RWMutex
 	activeModules   map[string]*module[T]
 
+	// scopes is the last scope given to a call to Instance.Run, see ScopedExtension
+	scopes atomic.Uint64
+
+	// lifecycleMu protects closing and running, and drained is closed
+	// once the runtime is closing and there are no more running calls
+	lifecycleMu sync.Mutex
+	closing     bool
+	running     int
+	drained     chan struct{}
+
 	TraceDataCallback func(data string)
 }
 
 func New[T interfaces.Signature](config *Config[T]) (*Scale[T], error) {
 	r := &Scale[T]{
-		runtime:       wazero.NewRuntimeWithConfig(config.context, wazero.NewRuntimeConfig().WithCloseOnContextDone(true)),
 		moduleConfig:  wazero.NewModuleConfig().WithSysNanotime().WithSysWalltime().WithRandSource(rand.Reader),
 		activeModules: make(map[string]*module[T]),
+		compiled:      make(map[[sha256.Size]byte]*compiledModule),
+		drained:       make(chan struct{}),
 		config:        config,
 	}
 
@@ -67,10 +115,59 @@ func New[T interfaces.Signature](config *Config[T]) (*Scale[T], error) {
 // Instance returns a new instance of a Scale Function chain
 // with the provided and optional next function.
 func (r *Scale[T]) Instance(next ...Next[T]) (*Instance[T], error) {
+	err := r.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer r.release()
 	return newInstance(r.config.context, r, next...)
 }
 
+// AcquireInstance returns a ready instance from the pool of warm instances (see Config.WithWarmInstances)
+// with the provided and optional next function, or a new instance if there are no warm instances available.
+//
+// The instance can be returned to the pool using ReleaseInstance once it is no longer in use.
+func (r *Scale[T]) AcquireInstance(ctx context.Context, next ...Next[T]) (*Instance[T], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if r.instances == nil {
+		err := r.acquire()
+		if err != nil {
+			return nil, err
+		}
+		defer r.release()
+		return newInstance(ctx, r, next...)
+	}
+
+	instance, err := r.instances.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	instance.setNext(next...)
+	return instance, nil
+}
+
+// ReleaseInstance returns an instance retrieved with AcquireInstance to the pool of warm instances,
+// so that it can be reused by a later call to AcquireInstance. The instance must not be used afterwards.
+//
+// Stateful functions keep their state when their instance is reused, but the Next function and the recorder
+// (see Instance.SetRecorder) of the instance are cleared, so that they are never called for its next user.
+// Instances are closed (see Instance.Close) if the pool is already full, or if the function chain was
+// changed since the instance was created.
+func (r *Scale[T]) ReleaseInstance(instance *Instance[T]) {
+	if r.instances == nil || instance == nil {
+		return
+	}
+	instance.setNext()
+	instance.SetRecorder(nil)
+	r.instances.Put(instance)
+}
+
 func (r *Scale[T]) Clear() {
+	r.activeModulesMu.Lock()
+	defer r.activeModulesMu.Unlock()
 	for key := range r.activeModules {
 		r.activeModules[key].instantiatedModule.CloseWithExitCode(r.config.context, 0)
 		delete(r.activeModules, key)
@@ -79,25 +176,144 @@ func (r *Scale[T]) Clear() {
 	r.activeModules = make(map[string]*module[T])
 }
 
-// Reset any extensions between executions.
+// Close stops the runtime from creating new instances or running functions,
+// and waits for any in-flight calls to complete (or for the given context to be done).
+//
+// Afterwards, the module pools, compiled modules, and the underlying wasm runtime
+// are closed, and any errors that occurred while doing so are returned. If the context is done
+// before all in-flight calls complete, they are aborted and the context's error is returned as well.
+func (r *Scale[T]) Close(ctx context.Context) error {
+	r.lifecycleMu.Lock()
+	if r.closing {
+		r.lifecycleMu.Unlock()
+		return ErrClosed
+	}
+	r.closing = true
+	if r.running == 0 {
+		close(r.drained)
+	}
+	r.lifecycleMu.Unlock()
+
+	var errs []error
+	select {
+	case <-r.drained:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("failed to wait for running functions: %w", ctx.Err()))
+	}
+
+	// The context may already be done, but closing the runtime must still happen
+	closeCtx := context.Background()
+
+	if r.instances != nil {
+		r.instances.Close()
+	}
+
+	// Retired templates are closed here, and not when the instances using them are closed afterwards
+	r.templatesMu.Lock()
+	templates := append(append([]*template[T]{}, r.templates...), r.retired...)
+	r.retired = nil
+	r.templatesMu.Unlock()
+
+	for _, t := range flattenTemplates(templates) {
+		if t.modulePool != nil {
+			t.modulePool.Close()
+		}
+	}
+
+	r.Clear()
+
+	for _, t := range templates {
+		err := t.close(closeCtx)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err := r.runtime.Close(closeCtx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to close wasm runtime: %w", err))
+	}
+
+	if r.compilationCache != nil {
+		err = r.compilationCache.Close(closeCtx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to close compilation cache: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// acquire registers a running call with the runtime, and returns ErrClosed if the runtime is closing
+func (r *Scale[T]) acquire() error {
+	r.lifecycleMu.Lock()
+	defer r.lifecycleMu.Unlock()
+	if r.closing {
+		return ErrClosed
+	}
+	r.running++
+	return nil
+}
+
+// release unregisters a running call that was registered using acquire
+func (r *Scale[T]) release() {
+	r.lifecycleMu.Lock()
+	defer r.lifecycleMu.Unlock()
+	r.running--
+	if r.closing && r.running == 0 {
+		close(r.drained)
+	}
+}
+
+// PoolStats returns the module pool statistics for every stateless function in the chain
+func (r *Scale[T]) PoolStats() []PoolStats {
+	var stats []PoolStats
+	templates, _ := r.chain()
+	for _, t := range flattenTemplates(templates) {
+		if t.modulePool != nil {
+			stats = append(stats, t.modulePool.Stats())
+		}
+	}
+	return stats
+}
+
+// resetExtensions resets the state of every extension that is not scoped to a single call
 func (r *Scale[T]) resetExtensions() {
 	for _, ext := range r.config.extensions {
-		ext.Reset()
+		if _, ok := ext.(ScopedExtension); !ok {
+			ext.Reset()
+		}
 	}
 }
 
-func (r *Scale[T]) init() error {
-	err := r.config.validate()
+func (r *Scale[T]) init() (err error) {
+	err = r.config.validate()
 	if err != nil {
 		return err
 	}
 
-	if r.config.stdout != nil && r.config.rawOutput {
-		r.moduleConfig = r.moduleConfig.WithStdout(r.config.stdout)
+	runtimeConfig := newRuntimeConfig()
+	if r.config.compilationCacheDir != "" {
+		r.compilationCacheDir = filepath.Join(r.config.compilationCacheDir, "scale-"+strings.TrimSpace(version.Version()))
+		r.compilationCache, err = wazero.NewCompilationCacheWithDir(r.compilationCacheDir)
+		if err != nil {
+			return fmt.Errorf("failed to create compilation cache: %w", err)
+		}
+		runtimeConfig = runtimeConfig.WithCompilationCache(r.compilationCache)
 	}
+	r.runtime = wazero.NewRuntimeWithConfig(r.config.context, runtimeConfig)
 
-	if r.config.stderr != nil && r.config.rawOutput {
-		r.moduleConfig = r.moduleConfig.WithStderr(r.config.stderr)
+	var templates []*template[T]
+	defer func() {
+		if err != nil {
+			r.closeInit(templates)
+		}
+	}()
+
+	if r.config.tracerProvider != nil {
+		r.tracer = r.config.tracerProvider.Tracer(TracerName)
+	} else {
+		r.tracer = trace.NewNoopTracerProvider().Tracer(TracerName)
 	}
 
 	envModule := r.runtime.NewHostModuleBuilder("env")
@@ -106,19 +322,8 @@ func (r *Scale[T]) init() error {
 	for _, ext := range r.config.extensions {
 		fns := ext.Init()
 		for name, fn := range fns {
-			wfn := func(_ string, f extension.InstallableFunc) func(context.Context, api.Module, []uint64) {
-				return func(_ context.Context, mod api.Module, params []uint64) {
-					mem := mod.Memory()
-					resize := func(name string, size uint64) (uint64, error) {
-						w, err := mod.ExportedFunction(name).Call(context.Background(), size)
-						return w[0], err
-					}
-					f(mem, resize, params)
-				}
-			}(name, fn)
-
 			envModule.NewFunctionBuilder().
-				WithGoModuleFunction(api.GoModuleFunc(wfn), []api.ValueType{api.ValueTypeI64, api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI64}).
+				WithGoModuleFunction(r.extensionFunction(name, fn), []api.ValueType{api.ValueTypeI64, api.ValueTypeI32, api.ValueTypeI32}, []api.ValueType{api.ValueTypeI64}).
 				WithParameterNames("instance", "pointer", "length").Export(name)
 		}
 	}
@@ -158,31 +363,355 @@ func (r *Scale[T]) init() error {
 		return fmt.Errorf("failed to instantiate host module wasi: %w", err)
 	}
 
-	testSignature := r.config.newSignature()
+	templates = make([]*template[T], 0, len(r.config.functions))
 	for _, sf := range r.config.functions {
-		if testSignature.Hash() != "" && testSignature.Hash() != sf.function.Signature.Hash {
-			return fmt.Errorf("passed in function '%s:%s' has an invalid signatures", sf.function.Name, sf.function.Tag)
+		if sf.branches != nil {
+			branches := make([]*template[T], 0, len(sf.branches))
+			for _, b := range sf.branches {
+				t, err := r.newTemplate(b.function, b.config)
+				if err != nil {
+					templates = append(templates, branches...)
+					return err
+				}
+				branches = append(branches, t)
+			}
+			templates = append(templates, newFanOutTemplate(r, branches, sf.merge))
+			continue
+		}
+
+		t, err := r.newTemplate(sf.function, sf.config)
+		if err != nil {
+			return err
 		}
+		templates = append(templates, t)
+	}
+	r.templates = templates
+
+	if r.config.warmInstances > 0 {
+		r.instances, err = newInstancePool(r, r.config.warmInstances)
+		if err != nil {
+			return fmt.Errorf("failed to create warm instances: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// closeInit closes the given templates, the wasm runtime and the compilation cache after init failed
+func (r *Scale[T]) closeInit(templates []*template[T]) {
+	ctx := context.Background()
+	for _, t := range templates {
+		_ = t.close(ctx)
+	}
+	_ = r.runtime.Close(ctx)
+	if r.compilationCache != nil {
+		_ = r.compilationCache.Close(ctx)
+	}
+}
+
+// newTemplate validates the signature (and if required, the signers) of the given function and pre-compiles it into a template
+func (r *Scale[T]) newTemplate(function *scalefunc.V1BetaSchema, config *FunctionConfig) (*template[T], error) {
+	if function == nil {
+		return nil, ErrInvalidFunction
+	}
+
+	testSignature := r.config.newSignature()
+	if testSignature.Hash() != "" && testSignature.Hash() != function.Signature.Hash {
+		return nil, fmt.Errorf("passed in function '%s:%s' has an invalid signatures", function.Name, function.Tag)
+	}
 
-		t, err := newTemplate(r.config.context, r, sf.function, sf.env)
+	if len(r.config.trustedKeys) > 0 {
+		err := scalefunc.Verify(function, r.config.trustedKeys...)
 		if err != nil {
-			return fmt.Errorf("failed to pre-compile function '%s:%s': %w", sf.function.Name, sf.function.Tag, err)
+			return nil, fmt.Errorf("failed to verify function '%s:%s': %w", function.Name, function.Tag, err)
+		}
+	}
+
+	t, err := newTemplate(r.config.context, r, function, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pre-compile function '%s:%s': %w", function.Name, function.Tag, err)
+	}
+
+	return t, nil
+}
+
+// compile compiles the given wasm binary
+//
+// If compiling the binary fails while the compilation cache is enabled, the cache entry for the binary
+// may be corrupted, so that entry is removed (leaving every other entry intact) and the binary is compiled again
+//
+// Identical binaries share the same compiled module, which must be closed using closeCompiled.
+func (r *Scale[T]) compile(ctx context.Context, binary []byte) (wazero.CompiledModule, error) {
+	key := sha256.Sum256(binary)
+	if compiled := r.acquireCompiled(key, nil); compiled != nil {
+		return compiled, nil
+	}
+
+	compiled, err := r.runtime.CompileModule(ctx, binary)
+	if err != nil && r.compilationCache != nil {
+		removed, removeErr := removeCompilationCacheEntry(ctx, r.compilationCacheDir, binary)
+		if removeErr != nil {
+			return nil, fmt.Errorf("failed to remove compilation cache entry: %w", errors.Join(err, removeErr))
 		}
+		if removed {
+			compiled, err = r.runtime.CompileModule(ctx, binary)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The binary may have been compiled concurrently, in which case the module
+	// compiled here shares its compiled code and must not be closed
+	return r.acquireCompiled(key, compiled), nil
+}
+
+// compiledModule is a compiled module along with the number of templates using it
+type compiledModule struct {
+	module wazero.CompiledModule
+	refs   int
+}
 
-		if r.head == nil {
-			r.head = t
+// acquireCompiled returns the compiled module for the binary with the given hash and registers
+// the caller as one of its users, or registers the given compiled module if there is none
+func (r *Scale[T]) acquireCompiled(key [sha256.Size]byte, compiled wazero.CompiledModule) wazero.CompiledModule {
+	r.compiledMu.Lock()
+	defer r.compiledMu.Unlock()
+	if c, ok := r.compiled[key]; ok {
+		c.refs++
+		return c.module
+	}
+	if compiled != nil {
+		r.compiled[key] = &compiledModule{module: compiled, refs: 1}
+	}
+	return compiled
+}
+
+// closeCompiled closes a compiled module returned by compile once it is no longer used by any template
+func (r *Scale[T]) closeCompiled(ctx context.Context, compiled wazero.CompiledModule) error {
+	r.compiledMu.Lock()
+	for key, c := range r.compiled {
+		if c.module != compiled {
+			continue
+		}
+		if c.refs--; c.refs > 0 {
+			r.compiledMu.Unlock()
+			return nil
 		}
+		delete(r.compiled, key)
+		break
+	}
+	r.compiledMu.Unlock()
+	return compiled.Close(ctx)
+}
+
+// chain returns the current templates in the function chain, along with its generation
+//
+// The returned slice must not be modified
+func (r *Scale[T]) chain() ([]*template[T], uint64) {
+	r.templatesMu.RLock()
+	defer r.templatesMu.RUnlock()
+	return r.templates, r.generation
+}
+
+// acquireChain is like chain, but also registers the caller as a user of the returned templates,
+// which are not closed until the caller passes them to releaseChain
+func (r *Scale[T]) acquireChain() ([]*template[T], uint64) {
+	r.templatesMu.RLock()
+	defer r.templatesMu.RUnlock()
+	for _, t := range r.templates {
+		t.instances.Add(1)
+	}
+	return r.templates, r.generation
+}
+
+// releaseChain unregisters a user of the given templates that were returned by acquireChain,
+// and closes any of them that are no longer part of the chain once they are no longer used
+func (r *Scale[T]) releaseChain(templates []*template[T]) {
+	for _, t := range templates {
+		t.instances.Add(-1)
+	}
 
-		if r.tail != nil {
-			r.tail.next = t
+	var unused []*template[T]
+	r.templatesMu.Lock()
+	retired := r.retired[:0]
+	for _, t := range r.retired {
+		if t.instances.Load() == 0 {
+			unused = append(unused, t)
+		} else {
+			retired = append(retired, t)
 		}
+	}
+	r.retired = retired
+	r.templatesMu.Unlock()
+
+	for _, t := range unused {
+		_ = t.close(context.Background())
+	}
+}
+
+// retire removes the given template from use once no instance is using it anymore
+//
+// The caller must hold templatesMu, and must have removed the template from the chain
+func (r *Scale[T]) retire(t *template[T]) {
+	if t.instances.Load() == 0 {
+		_ = t.close(context.Background())
+		return
+	}
+	r.retired = append(r.retired, t)
+}
+
+// ReplaceFunction replaces the function with the given name (either `<name>` or `<name>:<tag>`)
+// in the chain with a new function.
+//
+// Existing instances continue to use the previous function, while instances created
+// after this call use the new one. The previous function is closed once every instance
+// using it is closed (see Instance.Close). If no FunctionConfig is provided, the configuration
+// of the replaced function is reused.
+func (r *Scale[T]) ReplaceFunction(name string, function *scalefunc.V1BetaSchema, config ...*FunctionConfig) error {
+	err := r.acquire()
+	if err != nil {
+		return err
+	}
+	defer r.release()
+
+	// The function is compiled without holding templatesMu, so that the chain can still be used in the meantime
+	r.templatesMu.RLock()
+	index := r.findTemplate(name)
+	var functionConfig *FunctionConfig
+	if index >= 0 {
+		functionConfig = r.templates[index].config
+	}
+	r.templatesMu.RUnlock()
+	if index < 0 {
+		return fmt.Errorf("%w: %s", ErrFunctionNotFound, name)
+	}
+
+	if len(config) > 0 && config[0] != nil {
+		functionConfig = config[0]
+	}
+	err = functionConfig.validate()
+	if err != nil {
+		return err
+	}
+
+	t, err := r.newTemplate(function, functionConfig)
+	if err != nil {
+		return err
+	}
+
+	r.templatesMu.Lock()
+	defer r.templatesMu.Unlock()
+
+	// The chain may have changed while the function was being compiled
+	index = r.findTemplate(name)
+	if index < 0 {
+		_ = t.close(context.Background())
+		return fmt.Errorf("%w: %s", ErrFunctionNotFound, name)
+	}
+
+	templates := make([]*template[T], len(r.templates))
+	copy(templates, r.templates)
+	templates[index] = t
+
+	r.retire(r.templates[index])
+	r.templates = templates
+	r.generation++
+	return nil
+}
+
+// InsertFunction inserts a new function into the chain at the given index,
+// with an index of 0 inserting it at the head of the chain and an index equal to the
+// length of the chain appending it to the end.
+//
+// Existing instances continue to use the previous chain, while instances created
+// after this call use the new one.
+func (r *Scale[T]) InsertFunction(index int, function *scalefunc.V1BetaSchema, config ...*FunctionConfig) error {
+	err := r.acquire()
+	if err != nil {
+		return err
+	}
+	defer r.release()
+
+	functionConfig := NewFunctionConfig()
+	if len(config) > 0 && config[0] != nil {
+		functionConfig = config[0]
+	}
+	err = functionConfig.validate()
+	if err != nil {
+		return err
+	}
+
+	// The function is compiled without holding templatesMu, so that the chain can still be used in the meantime
+	t, err := r.newTemplate(function, functionConfig)
+	if err != nil {
+		return err
+	}
+
+	r.templatesMu.Lock()
+	defer r.templatesMu.Unlock()
+
+	if index < 0 || index > len(r.templates) {
+		_ = t.close(context.Background())
+		return fmt.Errorf("%w: %d", ErrInvalidIndex, index)
+	}
+
+	templates := make([]*template[T], 0, len(r.templates)+1)
+	templates = append(templates, r.templates[:index]...)
+	templates = append(templates, t)
+	templates = append(templates, r.templates[index:]...)
+
+	r.templates = templates
+	r.generation++
+	return nil
+}
+
+// RemoveFunction removes the function with the given name (either `<name>` or `<name>:<tag>`) from the chain
+//
+// Existing instances continue to use the previous chain, while instances created after this call
+// use the new one. The removed function is closed once every instance using it is closed (see Instance.Close).
+// The last function in a chain cannot be removed.
+func (r *Scale[T]) RemoveFunction(name string) error {
+	err := r.acquire()
+	if err != nil {
+		return err
+	}
+	defer r.release()
+
+	r.templatesMu.Lock()
+	defer r.templatesMu.Unlock()
 
-		r.tail = t
+	index := r.findTemplate(name)
+	if index < 0 {
+		return fmt.Errorf("%w: %s", ErrFunctionNotFound, name)
 	}
 
+	if len(r.templates) == 1 {
+		return ErrNoFunctions
+	}
+
+	templates := make([]*template[T], 0, len(r.templates)-1)
+	templates = append(templates, r.templates[:index]...)
+	templates = append(templates, r.templates[index+1:]...)
+
+	r.retire(r.templates[index])
+	r.templates = templates
+	r.generation++
 	return nil
 }
 
+// findTemplate returns the index of the first template in the chain matching the given name, or -1
+//
+// The caller must hold templatesMu
+func (r *Scale[T]) findTemplate(name string) int {
+	for i, t := range r.templates {
+		if t.identifier == name || t.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
 func (r *Scale[T]) next(ctx context.Context, module api.Module, params []uint64) {
 	r.activeModulesMu.RLock()
 	m := r.activeModules[module.Name()]
@@ -191,6 +720,8 @@ func (r *Scale[T]) next(ctx context.Context, module api.Module, params []uint64)
 		return
 	}
 
+	r.config.metrics.Next(m.template.identifier)
+
 	pointer := uint32(params[0])
 	length := uint32(params[1])
 	buf, ok := m.instantiatedModule.Memory().Read(pointer, length)
@@ -198,31 +729,100 @@ func (r *Scale[T]) next(ctx context.Context, module api.Module, params []uint64)
 		return
 	}
 
-	err := m.signature.Read(buf)
+	instance := m.function.instance
+	if instance.replay != nil {
+		call, ok := instance.replay.call(NextCall, "", buf)
+		if !ok {
+			return
+		}
+		buf = call.Response
+	} else {
+		index := -1
+		if instance.recordingCalls() {
+			index = instance.recordCall(RecordedCall{
+				Function: m.template.identifier,
+				Kind:     NextCall,
+				Request:  append([]byte{}, buf...),
+			})
+		}
+
+		err := m.signature.Read(buf)
+		if err != nil {
+			return
+		}
+
+		// Functions in a fan-out return their signature unchanged, and
+		// the merged signature is passed on to the next function instead
+		if m.function.fanOut == nil {
+			m.signature, err = m.function.runNext(ctx, m.signature)
+		}
+		if err != nil {
+			buf = m.signature.Error(err)
+		} else {
+			buf = m.signature.Write()
+			if err = m.checkSignatureSize(len(buf)); err != nil {
+				buf = m.signature.Error(err)
+			}
+		}
+
+		instance.recordResponse(index, buf)
+	}
+
+	writeBuffer, err := m.resizeFunction.Call(ctx, uint64(len(buf)))
 	if err != nil {
 		return
 	}
+	if m.instantiatedModule.Memory().Write(uint32(writeBuffer[0]), buf) {
+		r.config.metrics.Resize(m.template.identifier, len(buf))
+	}
+}
 
-	if m.function.next != nil {
-		nextModule, err := m.function.next.getModule(m.signature)
-		if err == nil {
-			err = nextModule.run(ctx)
-			if err == nil {
-				m.function.next.putModule(nextModule)
-			}
-		}
-	} else {
-		m.signature, err = m.function.instance.next(m.signature)
+// newRuntimeConfig returns the configuration of the wasm runtime, without the compilation cache
+func newRuntimeConfig() wazero.RuntimeConfig {
+	return wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+}
+
+// removeCompilationCacheEntry removes the entry for the given wasm binary from the compilation cache directory,
+// and returns false if there is no such entry or the binary cannot be compiled at all
+//
+// The name of an entry is derived from the binary and the runtime configuration by the wasm runtime,
+// so it is found by compiling the binary into an empty cache and looking at the entry that was created.
+func removeCompilationCacheEntry(ctx context.Context, dir string, binary []byte) (bool, error) {
+	scratch, err := os.MkdirTemp("", "scale-compilation-cache-")
+	if err != nil {
+		return false, err
 	}
+	defer os.RemoveAll(scratch)
+
+	cache, err := wazero.NewCompilationCacheWithDir(scratch)
 	if err != nil {
-		buf = m.signature.Error(err)
-	} else {
-		buf = m.signature.Write()
+		return false, err
 	}
+	defer cache.Close(ctx)
 
-	writeBuffer, err := m.resizeFunction.Call(ctx, uint64(len(buf)))
+	runtime := wazero.NewRuntimeWithConfig(ctx, newRuntimeConfig().WithCompilationCache(cache))
+	defer runtime.Close(ctx)
+
+	_, err = runtime.CompileModule(ctx, binary)
 	if err != nil {
-		return
+		return false, nil
 	}
-	m.instantiatedModule.Memory().Write(uint32(writeBuffer[0]), buf)
+
+	removed := false
+	err = filepath.WalkDir(scratch, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		entry, err := filepath.Rel(scratch, path)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(filepath.Join(dir, entry))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		removed = err == nil
+		return err
+	})
+	return removed, err
 }
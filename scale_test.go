@@ -0,0 +1,600 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/wasm-toolkit/pkg/wasm/wasmfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/scale/scalefunc"
+	"github.com/loopholelabs/scale/signature"
+)
+
+var (
+	testGuestOnce   sync.Once
+	testGuestBinary []byte
+	testGuestErr    error
+)
+
+// testGuest returns a scale function built from testdata/guest.wat
+//
+// The guest echoes its input back, and the first byte of the input selects
+// additional behaviour before the output is returned:
+//
+//	c - increment a counter kept in a global and store it in the second byte
+//	g - grow the memory until it cannot grow anymore, then trap
+//	l - loop forever
+//	n - call the `next` host function with the input
+//	o - perform an out of bounds memory access
+//	p - print the rest of the input to stdout
+//	s - overflow the stack
+//	t - trap with an unreachable instruction
+//	x - exit with exit code 3
+//	z - return 0 from `run`
+func testGuest(t testing.TB, name string, stateless bool) *scalefunc.V1BetaSchema {
+	t.Helper()
+
+	testGuestOnce.Do(func() {
+		wf, err := wasmfile.NewFromWat("testdata/guest.wat")
+		if err != nil {
+			testGuestErr = err
+			return
+		}
+		var buf bytes.Buffer
+		testGuestErr = wf.EncodeBinary(&buf)
+		testGuestBinary = buf.Bytes()
+	})
+	require.NoError(t, testGuestErr)
+
+	return &scalefunc.V1BetaSchema{
+		Name:      name,
+		Tag:       "latest",
+		Language:  scalefunc.Go,
+		Stateless: stateless,
+		Function:  testGuestBinary,
+	}
+}
+
+// testSignature is a signature that contains raw bytes,
+// errors are encoded as the error message prefixed with '!'
+type testSignature struct {
+	data []byte
+}
+
+func newTestSignature() *testSignature {
+	return new(testSignature)
+}
+
+func (s *testSignature) Read(b []byte) error {
+	if len(b) > 0 && b[0] == '!' {
+		return errors.New(string(b[1:]))
+	}
+	s.data = append(s.data[:0], b...)
+	return nil
+}
+
+func (s *testSignature) Write() []byte {
+	return s.data
+}
+
+func (s *testSignature) Error(err error) []byte {
+	return append([]byte{'!'}, err.Error()...)
+}
+
+func (s *testSignature) Hash() string {
+	return ""
+}
+
+func newTestScale(t testing.TB, config *Config[*testSignature]) *Scale[*testSignature] {
+	t.Helper()
+	r, err := New(config)
+	require.NoError(t, err)
+	return r
+}
+
+func runTestInstance(t testing.TB, instance *Instance[*testSignature], input string) (string, error) {
+	t.Helper()
+	sig := newTestSignature()
+	sig.data = []byte(input)
+	err := instance.Run(context.Background(), sig)
+	return string(sig.data), err
+}
+
+func TestRun(t *testing.T) {
+	var nextCalls int
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithFunction(testGuest(t, "second", false)))
+
+	instance, err := r.Instance(func(sig *testSignature) (*testSignature, error) {
+		nextCalls++
+		sig.data = append(sig.data, '!')
+		return sig, nil
+	})
+	require.NoError(t, err)
+
+	output, err := runTestInstance(t, instance, "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "echo", output)
+
+	output, err = runTestInstance(t, instance, "nn")
+	require.NoError(t, err)
+	assert.Equal(t, "nn!", output)
+	assert.Equal(t, 1, nextCalls)
+
+	_, err = runTestInstance(t, instance, "t")
+	assert.Error(t, err)
+
+	_, err = runTestInstance(t, instance, "z")
+	assert.Error(t, err)
+}
+
+func TestChangeFunctions(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithFunction(testGuest(t, "second", true)))
+
+	identifiers := func(instance *Instance[*testSignature]) (ids []string) {
+		for fn := instance.head; fn != nil; fn = fn.next {
+			ids = append(ids, fn.template.identifier)
+		}
+		return
+	}
+
+	before, err := r.Instance()
+	require.NoError(t, err)
+
+	err = r.ReplaceFunction("second", testGuest(t, "third", false))
+	require.NoError(t, err)
+
+	err = r.ReplaceFunction("missing", testGuest(t, "third", false))
+	assert.ErrorIs(t, err, ErrFunctionNotFound)
+
+	// Function configs are validated like the ones given to the Config
+	err = r.ReplaceFunction("first", testGuest(t, "third", true), NewFunctionConfig().WithMinIdleModules(1))
+	assert.ErrorIs(t, err, ErrInvalidPoolConfig)
+	err = r.InsertFunction(0, testGuest(t, "zeroth", true), NewFunctionConfig().WithMaxMemoryPages(maxMemoryPages+1))
+	assert.ErrorIs(t, err, ErrInvalidMemoryLimit)
+
+	replaced, err := r.Instance()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first:latest", "second:latest"}, identifiers(before))
+	assert.Equal(t, []string{"first:latest", "third:latest"}, identifiers(replaced))
+
+	err = r.InsertFunction(0, testGuest(t, "zeroth", true))
+	require.NoError(t, err)
+
+	compiledRefs := func() (refs int) {
+		r.compiledMu.Lock()
+		defer r.compiledMu.Unlock()
+		for _, c := range r.compiled {
+			refs += c.refs
+		}
+		return
+	}
+	refs := compiledRefs()
+
+	// The function is closed if it cannot be inserted after it was compiled
+	err = r.InsertFunction(4, testGuest(t, "fourth", true))
+	assert.ErrorIs(t, err, ErrInvalidIndex)
+	assert.Equal(t, refs, compiledRefs())
+
+	inserted, err := r.Instance()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zeroth:latest", "first:latest", "third:latest"}, identifiers(inserted))
+
+	err = r.RemoveFunction("first:latest")
+	require.NoError(t, err)
+
+	removed, err := r.Instance()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"zeroth:latest", "third:latest"}, identifiers(removed))
+
+	for _, instance := range []*Instance[*testSignature]{before, replaced, inserted, removed} {
+		output, err := runTestInstance(t, instance, "next")
+		require.NoError(t, err)
+		assert.Equal(t, "next", output)
+	}
+
+	require.NoError(t, r.RemoveFunction("zeroth"))
+	assert.ErrorIs(t, r.RemoveFunction("third"), ErrNoFunctions)
+}
+
+func TestRetiredFunctions(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", true), NewFunctionConfig().WithMaxModules(1)).
+		WithFunction(testGuest(t, "second", false)))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+	_, err = runTestInstance(t, instance, "c-")
+	require.NoError(t, err)
+
+	first := r.templates[0]
+	require.NoError(t, r.RemoveFunction("first"))
+	assert.Len(t, r.retired, 1)
+
+	// The instance keeps using the removed function until it is closed
+	_, err = runTestInstance(t, instance, "c-")
+	require.NoError(t, err)
+
+	stateful := instance.head.next.module.instantiatedModule
+	instance.Close()
+	assert.Empty(t, r.retired)
+	assert.Empty(t, r.activeModules)
+	assert.True(t, stateful.IsClosed())
+	select {
+	case <-first.modulePool.done:
+	default:
+		t.Fatal("module pool of removed function was not closed")
+	}
+
+	// Functions that are not used by any instance are closed right away
+	require.NoError(t, r.ReplaceFunction("second", testGuest(t, "third", true)))
+	assert.Empty(t, r.retired)
+
+	instance, err = r.Instance()
+	require.NoError(t, err)
+	output, err := runTestInstance(t, instance, "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "echo", output)
+}
+
+func TestClose(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithFunction(testGuest(t, "second", false)))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	running := make(chan error, 1)
+	go func() {
+		_, err := runTestInstance(t, instance, "l")
+		running <- err
+	}()
+
+	assert.Eventually(t, func() bool {
+		r.lifecycleMu.Lock()
+		defer r.lifecycleMu.Unlock()
+		return r.running == 1
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	err = r.Close(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Error(t, <-running)
+
+	_, err = r.Instance()
+	assert.ErrorIs(t, err, ErrClosed)
+
+	_, err = runTestInstance(t, instance, "echo")
+	assert.ErrorIs(t, err, ErrClosed)
+
+	assert.ErrorIs(t, r.Close(context.Background()), ErrClosed)
+}
+
+func TestCloseDrained(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", true), NewFunctionConfig().WithMaxModules(2).WithMinIdleModules(1)))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	_, err = runTestInstance(t, instance, "echo")
+	require.NoError(t, err)
+
+	err = r.Close(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0), r.PoolStats()[0].Total)
+}
+
+func TestCompilationCache(t *testing.T) {
+	dir := t.TempDir()
+	config := func() *Config[*testSignature] {
+		return NewConfig(newTestSignature).
+			WithFunction(testGuest(t, "first", true)).
+			WithFunctionConfig(testGuest(t, "second", true), NewFunctionConfig().WithMaxMemoryPages(16)).
+			WithCompilationCache(dir)
+	}
+
+	r := newTestScale(t, config())
+	require.NoError(t, r.Close(context.Background()))
+
+	entries := make(map[string][]byte)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			entries[path], err = os.ReadFile(path)
+		}
+		return err
+	})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	var corrupted, intact string
+	for entry := range entries {
+		if corrupted == "" {
+			corrupted = entry
+		} else {
+			intact = entry
+		}
+	}
+	require.NoError(t, os.WriteFile(corrupted, []byte("corrupted"), 0o600))
+	intactInfo, err := os.Stat(intact)
+	require.NoError(t, err)
+
+	r = newTestScale(t, config())
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	output, err := runTestInstance(t, instance, "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "echo", output)
+	require.NoError(t, r.Close(context.Background()))
+
+	// Only the corrupted entry was replaced, and the entry of the other function was left untouched
+	data, err := os.ReadFile(corrupted)
+	require.NoError(t, err)
+	assert.Equal(t, entries[corrupted], data)
+
+	info, err := os.Stat(intact)
+	require.NoError(t, err)
+	assert.Equal(t, intactInfo.ModTime(), info.ModTime())
+}
+
+func TestFanOut(t *testing.T) {
+	merge := func(sig *testSignature, results []*testSignature) (*testSignature, error) {
+		if string(sig.data) == "nm" {
+			return nil, errors.New("merge failed")
+		}
+		merged := newTestSignature()
+		for i, result := range results {
+			if i > 0 {
+				merged.data = append(merged.data, '+')
+			}
+			merged.data = append(merged.data, result.data...)
+		}
+		return merged, nil
+	}
+
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithFanOut(merge, testGuest(t, "second", true), testGuest(t, "third", false)))
+
+	instance, err := r.Instance(func(sig *testSignature) (*testSignature, error) {
+		sig.data = append(sig.data, '!')
+		return sig, nil
+	})
+	require.NoError(t, err)
+
+	output, err := runTestInstance(t, instance, "nn")
+	require.NoError(t, err)
+	assert.Equal(t, "nn+nn!", output)
+
+	_, err = runTestInstance(t, instance, "nm")
+	assert.ErrorContains(t, err, "merge failed")
+
+	assert.Len(t, r.PoolStats(), 2)
+
+	// (import "env" "missing" (func)) (memory 1), which compiles but cannot be instantiated
+	broken := testGuest(t, "broken", false)
+	broken.Function = append(append([]byte{}, wasmHeader...), 0x01, 0x04, 0x01, 0x60, 0x00, 0x00)
+	broken.Function = append(broken.Function, 0x02, 0x0f, 0x01, 0x03, 'e', 'n', 'v', 0x07, 'm', 'i', 's', 's', 'i', 'n', 'g', 0x00, 0x00)
+	broken.Function = append(broken.Function, 0x05, 0x03, 0x01, 0x00, 0x01)
+
+	// The branches that were created before a branch failed are closed
+	failing := newTestScale(t, NewConfig(newTestSignature).
+		WithFanOut(merge, testGuest(t, "second", false), broken))
+	_, err = failing.Instance()
+	require.Error(t, err)
+	failing.activeModulesMu.RLock()
+	assert.Empty(t, failing.activeModules)
+	failing.activeModulesMu.RUnlock()
+	require.NoError(t, failing.Close(context.Background()))
+
+	_, err = New(NewConfig(newTestSignature).WithFanOut(merge))
+	assert.ErrorIs(t, err, ErrInvalidFanOut)
+
+	_, err = New(NewConfig(newTestSignature).WithFanOut(nil, testGuest(t, "first", true)))
+	assert.ErrorIs(t, err, ErrInvalidFanOut)
+
+	require.NoError(t, r.Close(context.Background()))
+}
+
+func TestRouter(t *testing.T) {
+	var routes []string
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", true)).
+		WithFunction(testGuest(t, "second", true)).
+		WithFunction(testGuest(t, "third", false)).
+		WithRouter(func(sig *testSignature, from string) (string, error) {
+			routes = append(routes, from)
+			switch {
+			case from != "first:latest":
+				return "", nil
+			case len(sig.data) > 1 && sig.data[1] == 'e':
+				return "", errors.New("routing failed")
+			case len(sig.data) > 1 && sig.data[1] == 'm':
+				return "missing", nil
+			case len(sig.data) > 1 && sig.data[1] == 'd':
+				return RouteEnd, nil
+			}
+			return "third", nil
+		}))
+
+	instance, err := r.Instance(func(sig *testSignature) (*testSignature, error) {
+		sig.data = append(sig.data, '!')
+		return sig, nil
+	})
+	require.NoError(t, err)
+
+	output, err := runTestInstance(t, instance, "nn")
+	require.NoError(t, err)
+	assert.Equal(t, "nn!", output)
+	assert.Equal(t, []string{"first:latest", "third:latest"}, routes)
+
+	_, err = runTestInstance(t, instance, "ne")
+	assert.ErrorContains(t, err, "routing failed")
+
+	_, err = runTestInstance(t, instance, "nm")
+	assert.ErrorContains(t, err, ErrFunctionNotFound.Error())
+
+	routes = nil
+	output, err = runTestInstance(t, instance, "nd")
+	require.NoError(t, err)
+	assert.Equal(t, "nd!", output)
+	assert.Equal(t, []string{"first:latest"}, routes)
+}
+
+func TestRouterCycle(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)).
+		WithFunction(testGuest(t, "second", true)).
+		WithRouter(func(sig *testSignature, from string) (string, error) {
+			switch {
+			case from == "second:latest" && len(sig.data) > 1 && sig.data[1] == 'd':
+				return RouteEnd, nil
+			case from == "second:latest":
+				return "first", nil
+			case len(sig.data) > 1 && sig.data[1] == 's':
+				return from, nil
+			}
+			return "", nil
+		}))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	_, err = runTestInstance(t, instance, "ns")
+	assert.ErrorContains(t, err, ErrRouteCycle.Error())
+
+	_, err = runTestInstance(t, instance, "nn")
+	assert.ErrorContains(t, err, ErrRouteCycle.Error())
+
+	// Routing to the end of the chain instead of back to the first function breaks the cycle
+	output, err := runTestInstance(t, instance, "nd")
+	require.NoError(t, err)
+	assert.Equal(t, "nd", output)
+
+	output, err = runTestInstance(t, instance, "echo")
+	require.NoError(t, err)
+	assert.Equal(t, "echo", output)
+}
+
+func TestFunctionOutput(t *testing.T) {
+	var runtimeOutput, functionOutput bytes.Buffer
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", true), NewFunctionConfig().WithStdout(&functionOutput)).
+		WithStdout(&runtimeOutput).
+		WithRawOutput(true))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	_, err = runTestInstance(t, instance, "phello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", functionOutput.String())
+	assert.Empty(t, runtimeOutput.String())
+
+	_, err = New(NewConfig(newTestSignature).
+		WithFunctionConfig(testGuest(t, "first", true), NewFunctionConfig().WithWalltime(func() (int64, int32) { return 0, 0 }, 0)))
+	assert.ErrorIs(t, err, ErrInvalidClock)
+}
+
+func TestLogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)).
+		WithLogHandler(slog.NewJSONHandler(&buf, nil)))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	_, err = runTestInstance(t, instance, `p{"msg":"hello","user":"guest"}`)
+	require.NoError(t, err)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "hello", record["msg"])
+	assert.Equal(t, "guest", record["user"])
+	assert.Equal(t, "first", record["function"])
+	assert.Equal(t, "latest", record["tag"])
+	assert.Equal(t, "stdout", record["stream"])
+	assert.Equal(t, hex.EncodeToString(instance.identifier), record["instance"])
+}
+
+func TestTrustedKeys(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	schema := new(signature.Schema)
+	require.NoError(t, schema.Decode([]byte(signature.MasterTestingSchema)))
+	signed := func(name string, key ed25519.PrivateKey) *scalefunc.V1BetaSchema {
+		fn := testGuest(t, name, true)
+		fn.Signature.Schema = schema
+		if key != nil {
+			require.NoError(t, scalefunc.Sign(fn, key))
+		}
+		return fn
+	}
+
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(signed("first", private)).
+		WithTrustedKeys(public))
+
+	_, err = New(NewConfig(newTestSignature).
+		WithFunction(signed("first", nil)).
+		WithTrustedKeys(public))
+	assert.ErrorIs(t, err, scalefunc.ErrUnsigned)
+
+	_, err = New(NewConfig(newTestSignature).
+		WithFunction(signed("first", untrusted)).
+		WithTrustedKeys(public))
+	assert.ErrorIs(t, err, scalefunc.ErrUntrusted)
+
+	_, err = New(NewConfig(newTestSignature).
+		WithFunction(signed("first", private)).
+		WithTrustedKeys(public[:10]))
+	assert.ErrorIs(t, err, scalefunc.ErrInvalidKey)
+
+	// Functions added to the chain later on are verified as well
+	assert.ErrorIs(t, r.InsertFunction(1, signed("second", untrusted)), scalefunc.ErrUntrusted)
+	require.NoError(t, r.InsertFunction(1, signed("second", private)))
+
+	require.NoError(t, r.Close(context.Background()))
+}
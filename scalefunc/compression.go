@@ -0,0 +1,150 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	ErrCompression      = errors.New("unknown or invalid compression")
+	ErrDecompressedSize = errors.New("decompressed data exceeds size limit")
+)
+
+var (
+	// MaxDecompressedSize is the largest size that a compressed Manifest or Function is
+	// allowed to decompress to, which protects decoders from maliciously crafted Scale Functions
+	MaxDecompressedSize uint32 = 256 << 20
+)
+
+// Compression is the algorithm used to compress the Manifest and Function of a Scale Function
+type Compression string
+
+const (
+	// NoCompression stores the Manifest and Function of a Scale Function uncompressed
+	NoCompression Compression = "none"
+
+	// GzipCompression compresses the Manifest and Function of a Scale Function using gzip
+	GzipCompression Compression = "gzip"
+
+	// ZstdCompression compresses the Manifest and Function of a Scale Function using zstd
+	ZstdCompression Compression = "zstd"
+)
+
+var (
+	// AcceptedCompressions is an array of acceptable Compressions
+	AcceptedCompressions = []Compression{NoCompression, GzipCompression, ZstdCompression}
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdErr     error
+)
+
+// compressed returns true if the given compression compresses data
+func (c Compression) compressed() bool {
+	return c != "" && c != NoCompression
+}
+
+// Valid returns true if the compression is one of the AcceptedCompressions, or empty
+func (c Compression) Valid() bool {
+	if c == "" {
+		return true
+	}
+	for _, accepted := range AcceptedCompressions {
+		if c == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// zstdEncoderOnce returns the shared zstd encoder, which is safe for concurrent use
+func zstdEncoderOnce() (*zstd.Encoder, error) {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+	})
+	return zstdEncoder, zstdErr
+}
+
+// compress compresses the data using the given compression
+func compress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case "", NoCompression:
+		return data, nil
+	case GzipCompression:
+		var b bytes.Buffer
+		w := gzip.NewWriter(&b)
+		_, err := w.Write(data)
+		if err != nil {
+			return nil, err
+		}
+		err = w.Close()
+		if err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case ZstdCompression:
+		encoder, err := zstdEncoderOnce()
+		if err != nil {
+			return nil, err
+		}
+		return encoder.EncodeAll(data, nil), nil
+	default:
+		return nil, ErrCompression
+	}
+}
+
+// decompress decompresses the data that was compressed using the given compression,
+// and returns ErrDecompressedSize if it decompresses to more than MaxDecompressedSize bytes
+func decompress(c Compression, data []byte) ([]byte, error) {
+	var r io.Reader
+	switch c {
+	case "", NoCompression:
+		return data, nil
+	case GzipCompression:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case ZstdCompression:
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(MaxDecompressedSize)))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		return nil, ErrCompression
+	}
+
+	decompressed, err := io.ReadAll(io.LimitReader(r, int64(MaxDecompressedSize)+1))
+	if errors.Is(err, zstd.ErrDecoderSizeExceeded) || (err == nil && len(decompressed) > int(MaxDecompressedSize)) {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrDecompressedSize, MaxDecompressedSize)
+	}
+	return decompressed, err
+}
@@ -0,0 +1,106 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+	s := testSchema(t)
+	s.Manifest = bytes.Repeat([]byte("Test Manifest "), 64)
+	s.Function = bytes.Repeat([]byte("Test Function "), 1<<16)
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, Sign(s, private))
+
+	uncompressed := new(V1BetaSchema)
+	require.NoError(t, uncompressed.Decode(testEncode(t, s)))
+
+	for _, compression := range []Compression{GzipCompression, ZstdCompression} {
+		t.Run(string(compression), func(t *testing.T) {
+			s.Compression = compression
+			encoded := testEncode(t, s)
+			assert.Less(t, len(encoded), len(s.Function)/10)
+
+			decoded := new(V1BetaSchema)
+			require.NoError(t, decoded.Decode(encoded))
+			assert.Equal(t, compression, decoded.Compression)
+			assert.Equal(t, s.Manifest, decoded.Manifest)
+			assert.Equal(t, s.Function, decoded.Function)
+			assert.Equal(t, uncompressed.Size, decoded.Size)
+			assert.Equal(t, uncompressed.Hash, decoded.Hash)
+			assert.NoError(t, Verify(decoded, public))
+
+			// Decoding a compressed Scale Function and encoding it again produces the same bytes
+			assert.Equal(t, encoded, testEncode(t, decoded))
+
+			path := filepath.Join(t.TempDir(), "test.scale")
+			require.NoError(t, Write(path, s))
+
+			read, err := Read(path)
+			require.NoError(t, err)
+			assert.Equal(t, decoded, read)
+
+			metadata, err := ReadMetadata(path)
+			require.NoError(t, err)
+			assert.Nil(t, metadata.Function)
+			assert.Equal(t, s.Manifest, metadata.Manifest)
+			assert.Equal(t, compression, metadata.Compression)
+
+			// The hash is verified against the uncompressed Function
+			tampered := bytes.Replace(encoded, []byte(uncompressed.Hash), bytes.Repeat([]byte("0"), len(uncompressed.Hash)), 1)
+			assert.ErrorIs(t, new(V1BetaSchema).Decode(tampered), ErrHash)
+		})
+	}
+
+	s.Compression = "Invalid Compression"
+	_, err = s.Encode()
+	assert.ErrorIs(t, err, ErrCompression)
+	assert.ErrorIs(t, Write(filepath.Join(t.TempDir(), "test.scale"), s), ErrCompression)
+}
+
+func TestDecompressedSize(t *testing.T) {
+	defer func(size uint32) {
+		MaxDecompressedSize = size
+	}(MaxDecompressedSize)
+	MaxDecompressedSize = 1 << 16
+
+	s := testSchema(t)
+	s.Function = make([]byte, 1<<20)
+
+	for _, compression := range []Compression{GzipCompression, ZstdCompression} {
+		t.Run(string(compression), func(t *testing.T) {
+			s.Compression = compression
+			encoded := testEncode(t, s)
+			assert.Less(t, len(encoded), 1<<16)
+
+			assert.ErrorIs(t, new(V1BetaSchema).Decode(encoded), ErrDecompressedSize)
+			assert.ErrorIs(t, new(V1BetaSchema).DecodeFrom(bytes.NewReader(encoded)), ErrDecompressedSize)
+		})
+	}
+}
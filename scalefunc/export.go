@@ -0,0 +1,255 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+
+	extensionSchema "github.com/loopholelabs/scale/extension"
+	signatureSchema "github.com/loopholelabs/scale/signature"
+)
+
+// v1BetaDocument is the JSON and YAML representation of a V1BetaSchema, which stores the signature and
+// extension schemas as HCL and every binary field as base64 so that the document can be read by humans
+type v1BetaDocument struct {
+	Version     Version                `json:"version" yaml:"version"`
+	Name        string                 `json:"name" yaml:"name"`
+	Tag         string                 `json:"tag" yaml:"tag"`
+	Signature   v1BetaDocumentSchema   `json:"signature" yaml:"signature"`
+	Extensions  []v1BetaDocumentSchema `json:"extensions" yaml:"extensions"`
+	Language    Language               `json:"language" yaml:"language"`
+	Manifest    string                 `json:"manifest" yaml:"manifest"`
+	Stateless   bool                   `json:"stateless" yaml:"stateless"`
+	Function    string                 `json:"function" yaml:"function"`
+	Size        uint32                 `json:"size" yaml:"size"`
+	Hash        string                 `json:"hash" yaml:"hash"`
+	Signers     []v1BetaDocumentSigner `json:"signers,omitempty" yaml:"signers,omitempty"`
+	Compression Compression            `json:"compression,omitempty" yaml:"compression,omitempty"`
+}
+
+// v1BetaDocumentSchema is the JSON and YAML representation of a V1BetaSignature or V1BetaExtension
+type v1BetaDocumentSchema struct {
+	Name         string `json:"name" yaml:"name"`
+	Organization string `json:"organization" yaml:"organization"`
+	Tag          string `json:"tag" yaml:"tag"`
+	Schema       string `json:"schema" yaml:"schema"`
+	Hash         string `json:"hash" yaml:"hash"`
+}
+
+// v1BetaDocumentSigner is the JSON and YAML representation of a V1BetaSigner
+type v1BetaDocumentSigner struct {
+	PublicKey string `json:"public_key" yaml:"public_key"`
+	Signature string `json:"signature" yaml:"signature"`
+}
+
+// MarshalJSON encodes the Schema as an indented JSON document
+//
+// The signature and extension schemas are encoded as HCL, and the Manifest, Function and
+// signers are encoded as base64. The document can be decoded again using UnmarshalJSON.
+func MarshalJSON(s *V1BetaSchema) ([]byte, error) {
+	doc, err := newV1BetaDocument(s)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// UnmarshalJSON decodes a Schema from a JSON document created by MarshalJSON,
+// and returns ErrHash if the contents of the Schema do not match its hash
+func UnmarshalJSON(data []byte) (*V1BetaSchema, error) {
+	doc := new(v1BetaDocument)
+	err := json.Unmarshal(data, doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc.schema()
+}
+
+// MarshalYAML encodes the Schema as a YAML document, in the same form as MarshalJSON
+func MarshalYAML(s *V1BetaSchema) ([]byte, error) {
+	doc, err := newV1BetaDocument(s)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// UnmarshalYAML decodes a Schema from a YAML document created by MarshalYAML,
+// and returns ErrHash if the contents of the Schema do not match its hash
+func UnmarshalYAML(data []byte) (*V1BetaSchema, error) {
+	doc := new(v1BetaDocument)
+	err := yaml.Unmarshal(data, doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc.schema()
+}
+
+func newV1BetaDocument(s *V1BetaSchema) (*v1BetaDocument, error) {
+	doc := &v1BetaDocument{
+		Version:     V1Beta,
+		Name:        s.Name,
+		Tag:         s.Tag,
+		Extensions:  make([]v1BetaDocumentSchema, 0, len(s.Extensions)),
+		Language:    s.Language,
+		Manifest:    base64.StdEncoding.EncodeToString(s.Manifest),
+		Stateless:   s.Stateless,
+		Function:    base64.StdEncoding.EncodeToString(s.Function),
+		Size:        s.Size,
+		Hash:        s.Hash,
+		Compression: s.Compression,
+	}
+	if s.Compression.compressed() {
+		doc.Version = V1Beta2
+	}
+
+	if s.Signature.Schema == nil {
+		return nil, fmt.Errorf("signature '%s' has no schema", s.Signature.Name)
+	}
+	schema, err := s.Signature.Schema.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signature schema: %w", err)
+	}
+	doc.Signature = v1BetaDocumentSchema{
+		Name:         s.Signature.Name,
+		Organization: s.Signature.Organization,
+		Tag:          s.Signature.Tag,
+		Schema:       string(schema),
+		Hash:         s.Signature.Hash,
+	}
+
+	for _, ext := range s.Extensions {
+		if ext.Schema == nil {
+			return nil, fmt.Errorf("extension '%s' has no schema", ext.Name)
+		}
+		schema, err = ext.Schema.Encode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode extension schema: %w", err)
+		}
+		doc.Extensions = append(doc.Extensions, v1BetaDocumentSchema{
+			Name:         ext.Name,
+			Organization: ext.Organization,
+			Tag:          ext.Tag,
+			Schema:       string(schema),
+			Hash:         ext.Hash,
+		})
+	}
+
+	for _, signer := range s.Signers {
+		doc.Signers = append(doc.Signers, v1BetaDocumentSigner{
+			PublicKey: base64.StdEncoding.EncodeToString(signer.PublicKey),
+			Signature: base64.StdEncoding.EncodeToString(signer.Signature),
+		})
+	}
+
+	return doc, nil
+}
+
+func (doc *v1BetaDocument) schema() (*V1BetaSchema, error) {
+	switch doc.Version {
+	case V1Beta, V1Beta2:
+	default:
+		return nil, ErrVersion
+	}
+
+	s := &V1BetaSchema{
+		Name:        doc.Name,
+		Tag:         doc.Tag,
+		Language:    doc.Language,
+		Stateless:   doc.Stateless,
+		Size:        doc.Size,
+		Hash:        doc.Hash,
+		Compression: doc.Compression,
+	}
+
+	invalid := true
+	for _, l := range AcceptedLanguages {
+		if l == s.Language {
+			invalid = false
+			break
+		}
+	}
+	if invalid {
+		return nil, ErrLanguage
+	}
+
+	if !s.Compression.Valid() {
+		return nil, ErrCompression
+	}
+
+	var err error
+	s.Manifest, err = base64.StdEncoding.DecodeString(doc.Manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+
+	s.Function, err = base64.StdEncoding.DecodeString(doc.Function)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode function: %w", err)
+	}
+
+	s.Signature = V1BetaSignature{
+		Name:         doc.Signature.Name,
+		Organization: doc.Signature.Organization,
+		Tag:          doc.Signature.Tag,
+		Schema:       new(signatureSchema.Schema),
+		Hash:         doc.Signature.Hash,
+	}
+	err = s.Signature.Schema.Decode([]byte(doc.Signature.Schema))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature schema: %w", err)
+	}
+
+	s.Extensions = make([]V1BetaExtension, 0, len(doc.Extensions))
+	for _, ext := range doc.Extensions {
+		extension := V1BetaExtension{
+			Name:         ext.Name,
+			Organization: ext.Organization,
+			Tag:          ext.Tag,
+			Schema:       new(extensionSchema.Schema),
+			Hash:         ext.Hash,
+		}
+		err = extension.Schema.Decode([]byte(ext.Schema))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode schema of extension '%s': %w", ext.Name, err)
+		}
+		s.Extensions = append(s.Extensions, extension)
+	}
+
+	for _, signer := range doc.Signers {
+		publicKey, err := base64.StdEncoding.DecodeString(signer.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode signer public key: %w", err)
+		}
+		signature, err := base64.StdEncoding.DecodeString(signer.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode signer signature: %w", err)
+		}
+		s.Signers = append(s.Signers, V1BetaSigner{PublicKey: publicKey, Signature: signature})
+	}
+
+	if hex.EncodeToString(s.GetHash()) != s.Hash {
+		return nil, ErrHash
+	}
+
+	return s, nil
+}
@@ -0,0 +1,84 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/scale/extension"
+)
+
+func TestMarshalJSON(t *testing.T) {
+	extensionSchema := new(extension.Schema)
+	require.NoError(t, extensionSchema.Decode([]byte(extension.MasterTestingSchema)))
+
+	s := testSchema(t)
+	s.Extensions = []V1BetaExtension{{
+		Name:         "Test Extension",
+		Organization: "Test Organization",
+		Tag:          "Test Tag",
+		Schema:       extensionSchema,
+		Hash:         "Test Extension Hash",
+	}}
+	s.Manifest = []byte("Test Manifest")
+	s.Stateless = true
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, Sign(s, private))
+
+	for _, compression := range []Compression{"", ZstdCompression} {
+		s.Compression = compression
+		expected := new(V1BetaSchema)
+		require.NoError(t, expected.Decode(testEncode(t, s)))
+
+		data, err := MarshalJSON(expected)
+		require.NoError(t, err)
+
+		var document map[string]interface{}
+		require.NoError(t, json.Unmarshal(data, &document))
+		assert.Equal(t, "VGVzdCBGdW5jdGlvbiBDb250ZW50cw==", document["function"])
+		assert.Contains(t, document["signature"].(map[string]interface{})["schema"], "model ")
+
+		decoded, err := UnmarshalJSON(data)
+		require.NoError(t, err)
+		assert.Equal(t, expected, decoded)
+		assert.Equal(t, testEncode(t, expected), testEncode(t, decoded))
+
+		data, err = MarshalYAML(expected)
+		require.NoError(t, err)
+
+		decoded, err = UnmarshalYAML(data)
+		require.NoError(t, err)
+		assert.Equal(t, expected, decoded)
+	}
+
+	data, err := MarshalJSON(s)
+	require.NoError(t, err)
+	data = bytes.Replace(data, []byte("Test Name"), []byte("Tampered Name"), 1)
+	_, err = UnmarshalJSON(data)
+	assert.ErrorIs(t, err, ErrHash)
+}
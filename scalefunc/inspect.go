@@ -0,0 +1,124 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Inspect writes a human-readable description of the Schema to the given writer, including its
+// signature and extension schemas as HCL and whether its contents match its hash
+//
+// The hash is reported as not verified if the Function was not read, as is the case
+// for Schemas returned by ReadMetadata.
+func Inspect(w io.Writer, s *V1BetaSchema) error {
+	var b strings.Builder
+
+	version := V1Beta
+	compression := NoCompression
+	if s.Compression.compressed() {
+		version = V1Beta2
+		compression = s.Compression
+	}
+
+	fmt.Fprintf(&b, "Name:        %s\n", s.Name)
+	fmt.Fprintf(&b, "Tag:         %s\n", s.Tag)
+	fmt.Fprintf(&b, "Version:     %s\n", version)
+	fmt.Fprintf(&b, "Language:    %s\n", s.Language)
+	fmt.Fprintf(&b, "Stateless:   %t\n", s.Stateless)
+	fmt.Fprintf(&b, "Compression: %s\n", compression)
+	fmt.Fprintf(&b, "Size:        %d bytes\n", s.Size)
+	if s.Function != nil {
+		fmt.Fprintf(&b, "Function:    %d bytes\n", len(s.Function))
+	}
+	fmt.Fprintf(&b, "Manifest:    %d bytes\n", len(s.Manifest))
+
+	var hash []byte
+	status := "not verified, the function was not read"
+	if s.Function != nil {
+		hash = s.GetHash()
+		if hex.EncodeToString(hash) == s.Hash {
+			status = "verified"
+		} else {
+			status = "invalid, expected " + hex.EncodeToString(hash)
+		}
+	}
+	fmt.Fprintf(&b, "Hash:        %s (%s)\n", s.Hash, status)
+
+	for _, signer := range s.Signers {
+		status = "not verified"
+		if hash != nil {
+			if len(signer.PublicKey) == ed25519.PublicKeySize && ed25519.Verify(signer.PublicKey, hash, signer.Signature) {
+				status = "valid"
+			} else {
+				status = "invalid"
+			}
+		}
+		fmt.Fprintf(&b, "Signer:      %s (%s)\n", hex.EncodeToString(signer.PublicKey), status)
+	}
+
+	fmt.Fprintf(&b, "\nSignature:   %s (%s)\n", reference(s.Signature.Organization, s.Signature.Name, s.Signature.Tag), s.Signature.Hash)
+	if s.Signature.Schema != nil {
+		schema, err := s.Signature.Schema.Encode()
+		if err != nil {
+			return fmt.Errorf("failed to encode signature schema: %w", err)
+		}
+		writeIndented(&b, schema)
+	}
+
+	for _, ext := range s.Extensions {
+		fmt.Fprintf(&b, "\nExtension:   %s (%s)\n", reference(ext.Organization, ext.Name, ext.Tag), ext.Hash)
+		if ext.Schema != nil {
+			schema, err := ext.Schema.Encode()
+			if err != nil {
+				return fmt.Errorf("failed to encode schema of extension '%s': %w", ext.Name, err)
+			}
+			writeIndented(&b, schema)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// reference returns the organization/name:tag reference of a signature or extension
+func reference(organization string, name string, tag string) string {
+	ref := name
+	if organization != "" {
+		ref = organization + "/" + ref
+	}
+	if tag != "" {
+		ref = ref + ":" + tag
+	}
+	return ref
+}
+
+// writeIndented writes every non-empty line of the data indented by two spaces
+func writeIndented(b *strings.Builder, data []byte) {
+	for _, line := range bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n")) {
+		if len(line) > 0 {
+			b.WriteString("  ")
+			b.Write(line)
+		}
+		b.WriteByte('\n')
+	}
+}
@@ -0,0 +1,69 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspect(t *testing.T) {
+	s := testSchema(t)
+	s.Stateless = true
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, Sign(s, private))
+
+	path := filepath.Join(t.TempDir(), "test.scale")
+	require.NoError(t, Write(path, s))
+
+	read, err := Read(path)
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, Inspect(&b, read))
+	output := b.String()
+	assert.Contains(t, output, "Name:        Test Name\n")
+	assert.Contains(t, output, "Language:    go\n")
+	assert.Contains(t, output, "Stateless:   true\n")
+	assert.Contains(t, output, "Hash:        "+read.Hash+" (verified)\n")
+	assert.Contains(t, output, "Signer:      "+hex.EncodeToString(public)+" (valid)\n")
+	assert.Contains(t, output, "Signature:   Test Signature (Test Signature Hash)\n")
+	assert.Contains(t, output, "  context = ")
+
+	read.Function[0] = 'X'
+	b.Reset()
+	require.NoError(t, Inspect(&b, read))
+	assert.Contains(t, b.String(), "Hash:        "+read.Hash+" (invalid, expected ")
+	assert.Contains(t, b.String(), "(invalid)\n")
+
+	metadata, err := ReadMetadata(path)
+	require.NoError(t, err)
+	b.Reset()
+	require.NoError(t, Inspect(&b, metadata))
+	assert.Contains(t, b.String(), "(not verified, the function was not read)\n")
+	assert.Contains(t, b.String(), "(not verified)\n")
+}
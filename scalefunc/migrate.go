@@ -0,0 +1,201 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/loopholelabs/polyglot"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+var (
+	ErrMigration = errors.New("unable to migrate scale function")
+)
+
+// MigrateV1Alpha converts a V1Alpha Schema into a V1Beta Schema
+//
+// The signature name is split into its organization, name and tag, and the dependencies are converted into
+// a manifest for the language of the function: a go.mod file for Go, a Cargo.toml file for Rust and a
+// package.json file for TypeScript. Dependency metadata is only kept for Rust, where it is added to the
+// dependency's table. The size and hash are recomputed for the V1Beta encoding.
+func MigrateV1Alpha(v1Alpha *V1AlphaSchema) (*V1BetaSchema, error) {
+	if v1Alpha == nil {
+		return nil, fmt.Errorf("%w: schema is nil", ErrMigration)
+	}
+	if v1Alpha.SignatureSchema == nil {
+		return nil, fmt.Errorf("%w: signature '%s' has no schema", ErrMigration, v1Alpha.SignatureName)
+	}
+
+	manifest, err := v1AlphaManifest(v1Alpha)
+	if err != nil {
+		return nil, err
+	}
+
+	orgSplit := strings.Split(v1Alpha.SignatureName, "/")
+	if len(orgSplit) == 1 {
+		orgSplit = []string{"", v1Alpha.SignatureName}
+	}
+	tagSplit := strings.Split(orgSplit[1], ":")
+	if len(tagSplit) == 1 {
+		tagSplit = []string{tagSplit[0], ""}
+	}
+
+	s := &V1BetaSchema{
+		Name: v1Alpha.Name,
+		Tag:  v1Alpha.Tag,
+		Signature: V1BetaSignature{
+			Name:         tagSplit[0],
+			Organization: orgSplit[0],
+			Tag:          tagSplit[1],
+			Schema:       v1Alpha.SignatureSchema,
+			Hash:         v1Alpha.SignatureHash,
+		},
+		Extensions: []V1BetaExtension{},
+		Language:   v1Alpha.Language,
+		Manifest:   manifest,
+		Stateless:  v1Alpha.Stateless,
+		Function:   v1Alpha.Function,
+	}
+
+	b := polyglot.GetBuffer()
+	defer polyglot.PutBuffer(b)
+	s.encode(polyglot.Encoder(b), V1Beta, "", s.Manifest, s.Function)
+	hash := sha256.Sum256(b.Bytes())
+	s.Size = uint32(len(b.Bytes()))
+	s.Hash = hex.EncodeToString(hash[:])
+
+	return s, nil
+}
+
+// ReadAny opens the file at the given path and returns a *V1BetaSchema, regardless of the version
+// of the Scale Function in the file. V1Alpha Scale Functions are converted using MigrateV1Alpha.
+func ReadAny(path string) (*V1BetaSchema, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	version, err := newStreamDecoder(f).String()
+	if err != nil {
+		return nil, err
+	}
+
+	switch Version(version) {
+	case V1Alpha:
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		v1Alpha := new(V1AlphaSchema)
+		err = v1Alpha.Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		return MigrateV1Alpha(v1Alpha)
+	case V1Beta, V1Beta2:
+		_, err = f.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		scaleFunc := new(V1BetaSchema)
+		return scaleFunc, scaleFunc.DecodeFrom(f)
+	default:
+		return nil, ErrVersion
+	}
+}
+
+// v1AlphaManifest returns the manifest for the dependencies of a V1Alpha Schema
+func v1AlphaManifest(v1Alpha *V1AlphaSchema) ([]byte, error) {
+	switch v1Alpha.Language {
+	case Go:
+		f := &modfile.File{Syntax: new(modfile.FileSyntax)}
+		if v1Alpha.Name != "" {
+			err := f.AddModuleStmt(v1Alpha.Name)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrMigration, err)
+			}
+		}
+		for _, d := range v1Alpha.Dependencies {
+			version := d.Version
+			if !semver.IsValid(version) && semver.IsValid("v"+version) {
+				version = "v" + version
+			}
+			f.AddNewRequire(d.Name, version, false)
+		}
+		f.Cleanup()
+		return modfile.Format(f.Syntax), nil
+	case Rust:
+		var b strings.Builder
+		b.WriteString("[package]\n")
+		fmt.Fprintf(&b, "name = %s\n", tomlString(v1Alpha.Name))
+		b.WriteString("\n[dependencies]\n")
+		for _, d := range v1Alpha.Dependencies {
+			if len(d.Metadata) == 0 {
+				fmt.Fprintf(&b, "%s = %s\n", tomlString(d.Name), tomlString(d.Version))
+				continue
+			}
+			keys := make([]string, 0, len(d.Metadata))
+			for k := range d.Metadata {
+				if k != "version" {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			fmt.Fprintf(&b, "%s = { version = %s", tomlString(d.Name), tomlString(d.Version))
+			for _, k := range keys {
+				fmt.Fprintf(&b, ", %s = %s", tomlString(k), tomlString(d.Metadata[k]))
+			}
+			b.WriteString(" }\n")
+		}
+		return []byte(b.String()), nil
+	case TypeScript:
+		packageJSON := struct {
+			Name         string            `json:"name,omitempty"`
+			Dependencies map[string]string `json:"dependencies"`
+		}{
+			Name:         v1Alpha.Name,
+			Dependencies: make(map[string]string, len(v1Alpha.Dependencies)),
+		}
+		for _, d := range v1Alpha.Dependencies {
+			packageJSON.Dependencies[d.Name] = d.Version
+		}
+		data, err := json.MarshalIndent(packageJSON, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMigration, err)
+		}
+		return append(data, '\n'), nil
+	default:
+		return nil, ErrLanguage
+	}
+}
+
+// tomlString returns the string as a quoted TOML string, which uses the same escape sequences as JSON
+func tomlString(str string) string {
+	data, _ := json.Marshal(str)
+	return string(data)
+}
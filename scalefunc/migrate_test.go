@@ -0,0 +1,120 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/scale/signature"
+)
+
+func TestMigrateV1Alpha(t *testing.T) {
+	masterTestingSchema := new(signature.Schema)
+	require.NoError(t, masterTestingSchema.Decode([]byte(signature.MasterTestingSchema)))
+
+	v1Alpha := &V1AlphaSchema{
+		Name:            "test-name",
+		Tag:             "test-tag",
+		SignatureName:   "test-organization/test-signature:test-tag",
+		SignatureSchema: masterTestingSchema,
+		SignatureHash:   "Test Signature Hash",
+		Language:        Rust,
+		Stateless:       true,
+		Dependencies: []V1AlphaDependency{
+			{Name: "first", Version: "1.0.0"},
+			{Name: "second", Version: "2.0.0", Metadata: map[string]string{"path": "../second", "package": "second-package"}},
+		},
+		Function: []byte("Test Function Contents"),
+	}
+
+	migrated, err := MigrateV1Alpha(v1Alpha)
+	require.NoError(t, err)
+	assert.Equal(t, "test-name", migrated.Name)
+	assert.Equal(t, "test-tag", migrated.Tag)
+	assert.Equal(t, "test-organization", migrated.Signature.Organization)
+	assert.Equal(t, "test-signature", migrated.Signature.Name)
+	assert.Equal(t, "test-tag", migrated.Signature.Tag)
+	assert.Equal(t, "Test Signature Hash", migrated.Signature.Hash)
+	assert.Equal(t, masterTestingSchema, migrated.Signature.Schema)
+	assert.True(t, migrated.Stateless)
+	assert.Equal(t, v1Alpha.Function, migrated.Function)
+	assert.Equal(t, "[package]\nname = \"test-name\"\n\n[dependencies]\n\"first\" = \"1.0.0\"\n\"second\" = { version = \"2.0.0\", \"package\" = \"second-package\", \"path\" = \"../second\" }\n", string(migrated.Manifest))
+	assert.Equal(t, hex.EncodeToString(migrated.GetHash()), migrated.Hash)
+
+	decoded := new(V1BetaSchema)
+	require.NoError(t, decoded.Decode(testEncode(t, migrated)))
+	assert.Equal(t, migrated, decoded)
+
+	v1Alpha.Language = Go
+	migrated, err = MigrateV1Alpha(v1Alpha)
+	require.NoError(t, err)
+	assert.Equal(t, "module test-name\n\nrequire (\n\tfirst v1.0.0\n\tsecond v2.0.0\n)\n", string(migrated.Manifest))
+
+	v1Alpha.Language = TypeScript
+	migrated, err = MigrateV1Alpha(v1Alpha)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"name": "test-name", "dependencies": {"first": "1.0.0", "second": "2.0.0"}}`, string(migrated.Manifest))
+
+	v1Alpha.SignatureSchema = nil
+	_, err = MigrateV1Alpha(v1Alpha)
+	assert.ErrorIs(t, err, ErrMigration)
+}
+
+func TestReadAny(t *testing.T) {
+	masterTestingSchema := new(signature.Schema)
+	require.NoError(t, masterTestingSchema.Decode([]byte(signature.MasterTestingSchema)))
+
+	v1Alpha := &V1AlphaSchema{
+		Name:            "test-name",
+		Tag:             "test-tag",
+		SignatureName:   "test-signature",
+		SignatureSchema: masterTestingSchema,
+		Language:        Go,
+		Function:        []byte("Test Function Contents"),
+	}
+
+	dir := t.TempDir()
+	v1AlphaPath := filepath.Join(dir, "v1alpha.scale")
+	require.NoError(t, WriteV1Alpha(v1AlphaPath, v1Alpha))
+
+	expected, err := MigrateV1Alpha(v1Alpha)
+	require.NoError(t, err)
+
+	read, err := ReadAny(v1AlphaPath)
+	require.NoError(t, err)
+	assert.Equal(t, expected, read)
+
+	v1BetaPath := filepath.Join(dir, "v1beta.scale")
+	require.NoError(t, Write(v1BetaPath, expected))
+
+	read, err = ReadAny(v1BetaPath)
+	require.NoError(t, err)
+	assert.Equal(t, expected, read)
+
+	invalidPath := filepath.Join(dir, "invalid.scale")
+	require.NoError(t, os.WriteFile(invalidPath, []byte("invalid"), 0644))
+	_, err = ReadAny(invalidPath)
+	assert.Error(t, err)
+}
@@ -19,6 +19,7 @@
 package scalefunc
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -53,6 +54,9 @@ const (
 
 	// V1Beta is the V1 Beta definition of a Schema
 	V1Beta Version = "v1beta"
+
+	// V1Beta2 is the V1 Beta definition of a Schema with a compressed Manifest and Function
+	V1Beta2 Version = "v1beta2"
 )
 
 // Language is the Language the Scale Function's Source Language
@@ -382,55 +386,64 @@ type V1BetaSchema struct {
 	Function   []byte            `json:"function" yaml:"function"`
 	Size       uint32            `json:"size" yaml:"size"`
 	Hash       string            `json:"hash" yaml:"hash"`
+	Signers    []V1BetaSigner    `json:"signers,omitempty" yaml:"signers,omitempty"`
+
+	// Compression is the algorithm used to compress the Manifest and Function when the Schema is encoded
+	Compression Compression `json:"compression,omitempty" yaml:"compression,omitempty"`
 }
 
 // Encode encodes the Schema into a byte array
-func (s *V1BetaSchema) Encode() []byte {
+//
+// If Compression is set, the Manifest and Function are compressed and the Schema is encoded
+// as V1Beta2. The size and hash are always those of the uncompressed V1Beta encoding, so
+// compressing a Scale Function does not change its hash or invalidate its signatures.
+// ErrCompression is returned if Compression is not one of the AcceptedCompressions.
+func (s *V1BetaSchema) Encode() ([]byte, error) {
+	if !s.Compression.Valid() {
+		return nil, ErrCompression
+	}
+
 	b := polyglot.GetBuffer()
 	defer polyglot.PutBuffer(b)
 	e := polyglot.Encoder(b)
-	e.String(string(V1Beta))
-	e.String(s.Name)
-	e.String(s.Tag)
-
-	e.String(s.Signature.Name)
-	e.String(s.Signature.Organization)
-	e.String(s.Signature.Tag)
-	f := hclwrite.NewEmptyFile()
-	gohcl.EncodeIntoBody(s.Signature.Schema, f.Body())
-	e.Bytes(f.Bytes())
-	e.String(s.Signature.Hash)
-
-	e.Slice(uint32(len(s.Extensions)), polyglot.AnyKind)
-	for _, ext := range s.Extensions {
-		e.String(ext.Name)
-		e.String(ext.Organization)
-		e.String(ext.Tag)
-		f := hclwrite.NewEmptyFile()
-		gohcl.EncodeIntoBody(ext.Schema, f.Body())
-		e.Bytes(f.Bytes())
-		e.String(ext.Hash)
-	}
-
-	e.String(string(s.Language))
-
-	e.Bytes(s.Manifest)
-
-	e.Bool(s.Stateless)
-
-	e.Bytes(s.Function)
+	s.encode(e, V1Beta, "", s.Manifest, s.Function)
 
 	size := uint32(len(b.Bytes()))
 	hash := sha256.New()
 	hash.Write(b.Bytes())
 
+	if s.Compression.compressed() {
+		manifest, err := compress(s.Compression, s.Manifest)
+		if err != nil {
+			return nil, err
+		}
+		function, err := compress(s.Compression, s.Function)
+		if err != nil {
+			return nil, err
+		}
+		b.Reset()
+		s.encode(e, V1Beta2, s.Compression, manifest, function)
+	}
+
 	e.Uint32(size)
 	e.String(hex.EncodeToString(hash.Sum(nil)))
 
-	return b.Bytes()
+	// Signatures are not covered by the hash, and are only
+	// encoded if there are any so that older decoders ignore them
+	if len(s.Signers) > 0 {
+		e.Slice(uint32(len(s.Signers)), polyglot.AnyKind)
+		for _, signer := range s.Signers {
+			e.Bytes(signer.PublicKey)
+			e.Bytes(signer.Signature)
+		}
+	}
+
+	return b.Bytes(), nil
 }
 
 // Decode decodes the Schema from a byte array
+//
+// V1Alpha Scale Functions are converted into a V1Beta Schema using MigrateV1Alpha.
 func (s *V1BetaSchema) Decode(data []byte) error {
 	d := polyglot.GetDecoder(data)
 	defer d.Return()
@@ -447,31 +460,16 @@ func (s *V1BetaSchema) Decode(data []byte) error {
 		if err != nil {
 			return err
 		}
-		s.Name = v1Alpha.Name
-		s.Tag = v1Alpha.Tag
-
-		orgSplit := strings.Split(v1Alpha.SignatureName, "/")
-		if len(orgSplit) == 1 {
-			orgSplit = []string{"", v1Alpha.SignatureName}
-		}
-		tagSplit := strings.Split(orgSplit[1], ":")
-		if len(tagSplit) == 1 {
-			tagSplit = []string{tagSplit[0], ""}
+		migrated, err := MigrateV1Alpha(v1Alpha)
+		if err != nil {
+			return err
 		}
-
-		s.Signature.Name = tagSplit[0]
-		s.Signature.Organization = orgSplit[0]
-		s.Signature.Tag = tagSplit[1]
-
-		s.Signature.Schema = v1Alpha.SignatureSchema
-		s.Signature.Hash = v1Alpha.SignatureHash
-		s.Language = v1Alpha.Language
-		s.Stateless = v1Alpha.Stateless
-		s.Function = v1Alpha.Function
-		s.Size = v1Alpha.Size
+		*s = *migrated
 		return nil
 	case V1Beta:
-		break
+		s.Compression = ""
+	case V1Beta2:
+		return s.DecodeFrom(bytes.NewReader(data))
 	default:
 		return ErrVersion
 	}
@@ -608,15 +606,39 @@ func (s *V1BetaSchema) Decode(data []byte) error {
 		return ErrHash
 	}
 
+	s.Signers = nil
+	if signersOffset(s.Size, s.Hash) >= len(data) {
+		// The function is not signed
+		return nil
+	}
+
+	signersSize, err := d.Slice(polyglot.AnyKind)
+	if err != nil {
+		return err
+	}
+	s.Signers = make([]V1BetaSigner, signersSize)
+	for i := uint32(0); i < signersSize; i++ {
+		s.Signers[i].PublicKey, err = d.Bytes(nil)
+		if err != nil {
+			return err
+		}
+
+		s.Signers[i].Signature, err = d.Bytes(nil)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// GetHash returns the hash of the Schema
-func (s *V1BetaSchema) GetHash() []byte {
-	b := polyglot.GetBuffer()
-	defer polyglot.PutBuffer(b)
-	e := polyglot.Encoder(b)
-	e.String(string(V1Beta))
+// encode encodes everything but the size, hash and signers of the Schema using the given version, with the
+// given manifest and function in place of the Manifest and Function. The compression is only encoded for V1Beta2.
+func (s *V1BetaSchema) encode(e *polyglot.BufferEncoder, version Version, compression Compression, manifest []byte, function []byte) {
+	e.String(string(version))
+	if version == V1Beta2 {
+		e.String(string(compression))
+	}
 	e.String(s.Name)
 	e.String(s.Tag)
 
@@ -641,11 +663,26 @@ func (s *V1BetaSchema) GetHash() []byte {
 
 	e.String(string(s.Language))
 
-	e.Bytes(s.Manifest)
+	e.Bytes(manifest)
 
 	e.Bool(s.Stateless)
 
-	e.Bytes(s.Function)
+	e.Bytes(function)
+}
+
+// signersOffset returns the offset of the signers in an encoded V1Beta Schema with the given size and hash,
+// which are encoded right after the first size bytes
+func signersOffset(size uint32, hash string) int {
+	b := polyglot.NewBuffer()
+	polyglot.Encoder(b).Uint32(size).String(hash)
+	return int(size) + len(b.Bytes())
+}
+
+// GetHash returns the hash of the Schema
+func (s *V1BetaSchema) GetHash() []byte {
+	b := polyglot.GetBuffer()
+	defer polyglot.PutBuffer(b)
+	s.encode(polyglot.Encoder(b), V1Beta, "", s.Manifest, s.Function)
 
 	hash := sha256.New()
 	hash.Write(b.Bytes())
@@ -655,17 +692,21 @@ func (s *V1BetaSchema) GetHash() []byte {
 
 // ReadV1Beta opens a file at the given path and returns a *V1BetaSchema
 func ReadV1Beta(path string) (*V1BetaSchema, error) {
-	data, err := os.ReadFile(path)
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	scaleFunc := new(V1BetaSchema)
-	return scaleFunc, scaleFunc.Decode(data)
+	return scaleFunc, scaleFunc.DecodeFrom(f)
 }
 
 // WriteV1Beta opens a file at the given path and writes the given V1BetaSchema to it
 func WriteV1Beta(path string, scaleFunc *V1BetaSchema) error {
-	data := scaleFunc.Encode()
+	data, err := scaleFunc.Encode()
+	if err != nil {
+		return err
+	}
 	return os.WriteFile(path, data, 0644)
 }
 
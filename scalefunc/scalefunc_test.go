@@ -24,6 +24,7 @@ import (
 	"github.com/loopholelabs/scale/signature"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEncodeDecode(t *testing.T) {
@@ -48,8 +49,9 @@ func TestEncodeDecode(t *testing.T) {
 
 		decoded := new(V1AlphaSchema)
 
-		encoded := v1Beta.Encode()
-		err := decoded.Decode(encoded)
+		encoded, err := v1Beta.Encode()
+		require.NoError(t, err)
+		err = decoded.Decode(encoded)
 		assert.ErrorIs(t, err, ErrVersion)
 
 		v1Alpha := &V1AlphaSchema{
@@ -171,7 +173,8 @@ func TestEncodeDecode(t *testing.T) {
 			},
 		}
 
-		encoded = v1Beta.Encode()
+		encoded, err = v1Beta.Encode()
+		require.NoError(t, err)
 		err = decoded.Decode(encoded)
 		assert.ErrorIs(t, err, ErrLanguage)
 	})
@@ -0,0 +1,82 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid ed25519 key")
+	ErrUnsigned   = errors.New("function is not signed")
+	ErrUntrusted  = errors.New("function is not signed by a trusted key")
+)
+
+// V1BetaSigner is an ed25519 signature of a Scale Function, along with the public key that created it
+//
+// The signature covers the hash returned by V1BetaSchema.GetHash, so it remains valid as long as
+// the contents of the Scale Function are unchanged.
+type V1BetaSigner struct {
+	PublicKey ed25519.PublicKey `json:"public_key" yaml:"public_key"`
+	Signature []byte            `json:"signature" yaml:"signature"`
+}
+
+// Sign signs the Schema with the given private key, replacing any previous signature created with the same key
+func Sign(s *V1BetaSchema, key ed25519.PrivateKey) error {
+	if len(key) != ed25519.PrivateKeySize {
+		return ErrInvalidKey
+	}
+
+	signer := V1BetaSigner{
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, s.GetHash()),
+	}
+
+	for i, existing := range s.Signers {
+		if bytes.Equal(existing.PublicKey, signer.PublicKey) {
+			s.Signers[i] = signer
+			return nil
+		}
+	}
+	s.Signers = append(s.Signers, signer)
+	return nil
+}
+
+// Verify returns nil if the Schema carries a valid signature created by any of the trusted keys
+//
+// ErrUnsigned is returned if the Schema is not signed at all, and ErrUntrusted
+// if none of its signatures are valid signatures from a trusted key.
+func Verify(s *V1BetaSchema, trusted ...ed25519.PublicKey) error {
+	if len(s.Signers) == 0 {
+		return ErrUnsigned
+	}
+
+	hash := s.GetHash()
+	for _, signer := range s.Signers {
+		if len(signer.PublicKey) != ed25519.PublicKeySize {
+			continue
+		}
+		for _, key := range trusted {
+			if bytes.Equal(key, signer.PublicKey) && ed25519.Verify(key, hash, signer.Signature) {
+				return nil
+			}
+		}
+	}
+	return ErrUntrusted
+}
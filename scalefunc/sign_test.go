@@ -0,0 +1,128 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/scale/signature"
+)
+
+func testSchema(t *testing.T) *V1BetaSchema {
+	t.Helper()
+
+	masterTestingSchema := new(signature.Schema)
+	require.NoError(t, masterTestingSchema.Decode([]byte(signature.MasterTestingSchema)))
+
+	return &V1BetaSchema{
+		Name:     "Test Name",
+		Tag:      "Test Tag",
+		Language: Go,
+		Signature: V1BetaSignature{
+			Name:   "Test Signature",
+			Schema: masterTestingSchema,
+			Hash:   "Test Signature Hash",
+		},
+		Function: []byte("Test Function Contents"),
+	}
+}
+
+// testEncode encodes the Schema, and returns a copy of the encoded bytes since
+// the buffer returned by Encode is reused by later calls
+func testEncode(t *testing.T, s *V1BetaSchema) []byte {
+	t.Helper()
+
+	encoded, err := s.Encode()
+	require.NoError(t, err)
+	return append([]byte{}, encoded...)
+}
+
+func TestSignVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherPublic, otherPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	s := testSchema(t)
+	unsigned := testEncode(t, s)
+	assert.ErrorIs(t, Verify(s, public), ErrUnsigned)
+
+	require.NoError(t, Sign(s, private))
+	assert.NoError(t, Verify(s, public))
+	assert.NoError(t, Verify(s, otherPublic, public))
+	assert.ErrorIs(t, Verify(s, otherPublic), ErrUntrusted)
+	assert.ErrorIs(t, Sign(s, private[:10]), ErrInvalidKey)
+
+	// Signing again with the same key replaces the previous signature
+	require.NoError(t, Sign(s, private))
+	require.NoError(t, Sign(s, otherPrivate))
+	require.Len(t, s.Signers, 2)
+
+	decoded := new(V1BetaSchema)
+	require.NoError(t, decoded.Decode(testEncode(t, s)))
+	assert.Equal(t, s.Signers, decoded.Signers)
+	assert.NoError(t, Verify(decoded, public))
+	assert.NoError(t, Verify(decoded, otherPublic))
+
+	// The signatures are appended to the encoded function without changing its hash
+	assert.Equal(t, unsigned, testEncode(t, s)[:len(unsigned)])
+	require.NoError(t, decoded.Decode(unsigned))
+	assert.Empty(t, decoded.Signers)
+
+	// Modifying the function invalidates its signatures
+	s.Function = []byte("Modified Function Contents")
+	assert.ErrorIs(t, Verify(s, public), ErrUntrusted)
+}
+
+func TestDecodeCorruptSigners(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	for _, compression := range []Compression{"", ZstdCompression} {
+		t.Run(string(compression), func(t *testing.T) {
+			s := testSchema(t)
+			s.Compression = compression
+			unsigned := testEncode(t, s)
+			require.NoError(t, Sign(s, private))
+			signed := testEncode(t, s)
+
+			decoded := new(V1BetaSchema)
+			require.NoError(t, decoded.Decode(unsigned))
+			assert.Empty(t, decoded.Signers)
+			require.NoError(t, decoded.DecodeFrom(bytes.NewReader(unsigned)))
+			assert.Empty(t, decoded.Signers)
+
+			corrupted := map[string][]byte{
+				"truncated": signed[:len(signed)-1],
+				"partial":   signed[:len(unsigned)+1],
+				"garbage":   append(append([]byte{}, unsigned...), 0xff),
+			}
+			for name, data := range corrupted {
+				assert.Error(t, new(V1BetaSchema).Decode(data), name)
+				assert.Error(t, new(V1BetaSchema).DecodeFrom(bytes.NewReader(data)), name)
+			}
+		})
+	}
+}
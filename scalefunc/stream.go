@@ -0,0 +1,487 @@
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/loopholelabs/polyglot"
+
+	extensionSchema "github.com/loopholelabs/scale/extension"
+	signatureSchema "github.com/loopholelabs/scale/signature"
+)
+
+const (
+	// maxPreallocation is the largest buffer that is allocated up front when decoding
+	// a byte array from a reader, larger byte arrays grow as their data is read
+	maxPreallocation = 16 << 20
+)
+
+// DecodeFrom decodes the Schema from the given reader
+//
+// Unlike Decode, the encoded Schema does not have to be in memory, and the Function is read
+// directly into its own buffer. The hash is verified while the Schema is being read.
+func (s *V1BetaSchema) DecodeFrom(r io.Reader) error {
+	return s.decodeFrom(newStreamDecoder(r), true)
+}
+
+// ReadMetadata opens the file at the given path and decodes everything but the Function from it
+//
+// The Function is skipped without being read into memory, which makes ReadMetadata suitable for
+// inspecting the name, tag, signature and extensions of large Scale Functions. Since the Function is
+// not read, the hash of the Scale Function is not verified, and the returned Schema must not be run.
+func ReadMetadata(path string) (*V1BetaSchema, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scaleFunc := new(V1BetaSchema)
+	return scaleFunc, scaleFunc.decodeFrom(newStreamDecoder(f), false)
+}
+
+// decodeFrom decodes the Schema using the given decoder, and skips the Function if function is false
+func (s *V1BetaSchema) decodeFrom(d *streamDecoder, function bool) error {
+	if function {
+		d.hash = sha256.New()
+	}
+
+	version, err := d.String()
+	if err != nil {
+		return err
+	}
+
+	switch Version(version) {
+	case V1Alpha:
+		// V1Alpha Scale Functions are decoded in memory, since they are only supported for backwards compatibility
+		b := polyglot.GetBuffer()
+		defer polyglot.PutBuffer(b)
+		polyglot.Encoder(b).String(version)
+		rest, err := io.ReadAll(d.r)
+		if err != nil {
+			return err
+		}
+		return s.Decode(append(b.Bytes(), rest...))
+	case V1Beta:
+		s.Compression = ""
+	case V1Beta2:
+		// The hash of a compressed Scale Function is the hash of its uncompressed V1Beta encoding
+		if d.hash != nil {
+			d.hash.Reset()
+			d.size = 0
+			d.hashEncoded(func(e *polyglot.BufferEncoder) {
+				e.String(string(V1Beta))
+			})
+		}
+
+		h := d.hash
+		d.hash = nil
+		compression, err := d.String()
+		d.hash = h
+		if err != nil {
+			return err
+		}
+		s.Compression = Compression(compression)
+		if !s.Compression.Valid() {
+			return ErrCompression
+		}
+	default:
+		return ErrVersion
+	}
+
+	s.Name, err = d.String()
+	if err != nil {
+		return err
+	}
+
+	s.Tag, err = d.String()
+	if err != nil {
+		return err
+	}
+
+	s.Signature.Name, err = d.String()
+	if err != nil {
+		return err
+	}
+
+	s.Signature.Organization, err = d.String()
+	if err != nil {
+		return err
+	}
+
+	s.Signature.Tag, err = d.String()
+	if err != nil {
+		return err
+	}
+
+	signatureSchemaBytes, err := d.Bytes()
+	if err != nil {
+		return err
+	}
+
+	s.Signature.Schema = new(signatureSchema.Schema)
+	err = s.Signature.Schema.Decode(signatureSchemaBytes)
+	if err != nil {
+		return err
+	}
+
+	s.Signature.Hash, err = d.String()
+	if err != nil {
+		return err
+	}
+
+	extensionsSize, err := d.Slice(polyglot.AnyKind)
+	if err != nil {
+		return err
+	}
+	s.Extensions = make([]V1BetaExtension, 0, min(extensionsSize, 64))
+	for i := uint32(0); i < extensionsSize; i++ {
+		var ext V1BetaExtension
+		ext.Name, err = d.String()
+		if err != nil {
+			return err
+		}
+
+		ext.Organization, err = d.String()
+		if err != nil {
+			return err
+		}
+
+		ext.Tag, err = d.String()
+		if err != nil {
+			return err
+		}
+
+		extensionSchemaBytes, err := d.Bytes()
+		if err != nil {
+			return err
+		}
+
+		ext.Schema = new(extensionSchema.Schema)
+		err = ext.Schema.Decode(extensionSchemaBytes)
+		if err != nil {
+			return err
+		}
+
+		ext.Hash, err = d.String()
+		if err != nil {
+			return err
+		}
+		s.Extensions = append(s.Extensions, ext)
+	}
+
+	language, err := d.String()
+	if err != nil {
+		return err
+	}
+	s.Language = Language(language)
+
+	invalid := true
+	for _, l := range AcceptedLanguages {
+		if l == s.Language {
+			invalid = false
+			break
+		}
+	}
+	if invalid {
+		return ErrLanguage
+	}
+
+	s.Manifest, err = d.CompressedBytes(s.Compression)
+	if err != nil {
+		return err
+	}
+
+	s.Stateless, err = d.Bool()
+	if err != nil {
+		return err
+	}
+
+	if function {
+		s.Function, err = d.CompressedBytes(s.Compression)
+	} else {
+		s.Function = nil
+		err = d.SkipBytes()
+	}
+	if err != nil {
+		return err
+	}
+
+	size := d.size
+	sum := d.hash
+	d.hash = nil
+
+	s.Size, err = d.Uint32()
+	if err != nil {
+		return err
+	}
+
+	s.Hash, err = d.String()
+	if err != nil {
+		return err
+	}
+
+	if function && (size != s.Size || hex.EncodeToString(sum.Sum(nil)) != s.Hash) {
+		return ErrHash
+	}
+
+	s.Signers = nil
+	if d.EOF() {
+		// The function is not signed
+		return nil
+	}
+
+	signersSize, err := d.Slice(polyglot.AnyKind)
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < signersSize; i++ {
+		var signer V1BetaSigner
+		signer.PublicKey, err = d.Bytes()
+		if err != nil {
+			return err
+		}
+
+		signer.Signature, err = d.Bytes()
+		if err != nil {
+			return err
+		}
+		s.Signers = append(s.Signers, signer)
+	}
+
+	return nil
+}
+
+// streamDecoder decodes polyglot encoded values from a reader
+type streamDecoder struct {
+	r *bufio.Reader
+
+	// seeker is the underlying reader if it can seek, which is used to skip large byte arrays
+	seeker io.ReadSeeker
+
+	// hash, if it is set, is updated with every byte that is read, and size is the number of bytes read
+	hash hash.Hash
+	size uint32
+}
+
+func newStreamDecoder(r io.Reader) *streamDecoder {
+	d := &streamDecoder{r: bufio.NewReader(r)}
+	d.seeker, _ = r.(io.ReadSeeker)
+	return d
+}
+
+func (d *streamDecoder) read(p []byte) error {
+	_, err := io.ReadFull(d.r, p)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if d.hash != nil {
+		d.hash.Write(p)
+		d.size += uint32(len(p))
+	}
+	return nil
+}
+
+func (d *streamDecoder) kind(kind polyglot.Kind, invalid error) error {
+	var b [1]byte
+	err := d.read(b[:])
+	if err != nil {
+		return err
+	}
+	if b[0] != kind[0] {
+		return invalid
+	}
+	return nil
+}
+
+// EOF returns true if there is nothing left to read
+func (d *streamDecoder) EOF() bool {
+	_, err := d.r.Peek(1)
+	return err != nil
+}
+
+func (d *streamDecoder) Uint32() (uint32, error) {
+	err := d.kind(polyglot.Uint32Kind, polyglot.InvalidUint32)
+	if err != nil {
+		return 0, err
+	}
+
+	var x uint32
+	var s uint
+	var b [1]byte
+	for i := 0; i < polyglot.VarIntLen32; i++ {
+		err = d.read(b[:])
+		if err != nil {
+			return 0, err
+		}
+		if b[0] < 0x80 {
+			if i == polyglot.VarIntLen32-1 && b[0] > 1<<4-1 {
+				return 0, polyglot.InvalidUint32
+			}
+			return x | uint32(b[0])<<s, nil
+		}
+		x |= uint32(b[0]&0x7f) << s
+		s += 7
+	}
+	return 0, polyglot.InvalidUint32
+}
+
+func (d *streamDecoder) Bool() (bool, error) {
+	err := d.kind(polyglot.BoolKind, polyglot.InvalidBool)
+	if err != nil {
+		return false, err
+	}
+
+	var b [1]byte
+	err = d.read(b[:])
+	if err != nil {
+		return false, err
+	}
+	return b[0] == 1, nil
+}
+
+func (d *streamDecoder) Slice(kind polyglot.Kind) (uint32, error) {
+	err := d.kind(polyglot.SliceKind, polyglot.InvalidSlice)
+	if err != nil {
+		return 0, err
+	}
+	err = d.kind(kind, polyglot.InvalidSlice)
+	if err != nil {
+		return 0, err
+	}
+	return d.Uint32()
+}
+
+func (d *streamDecoder) String() (string, error) {
+	err := d.kind(polyglot.StringKind, polyglot.InvalidString)
+	if err != nil {
+		return "", err
+	}
+	size, err := d.Uint32()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.readBytes(size)
+	return string(b), err
+}
+
+func (d *streamDecoder) Bytes() ([]byte, error) {
+	err := d.kind(polyglot.BytesKind, polyglot.InvalidBytes)
+	if err != nil {
+		return nil, err
+	}
+	size, err := d.Uint32()
+	if err != nil {
+		return nil, err
+	}
+	return d.readBytes(size)
+}
+
+// CompressedBytes reads a byte array that was compressed using the given compression and decompresses it.
+// When hashing, the decompressed byte array is hashed as if it had been encoded uncompressed.
+func (d *streamDecoder) CompressedBytes(compression Compression) ([]byte, error) {
+	if !compression.compressed() {
+		return d.Bytes()
+	}
+
+	h := d.hash
+	d.hash = nil
+	compressed, err := d.Bytes()
+	d.hash = h
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := decompress(compression, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	d.hashEncoded(func(e *polyglot.BufferEncoder) {
+		e.Bytes(data)
+	})
+	return data, nil
+}
+
+// hashEncoded updates the hash, if it is set, as if the values encoded by the given function had been read
+func (d *streamDecoder) hashEncoded(encode func(e *polyglot.BufferEncoder)) {
+	if d.hash == nil {
+		return
+	}
+	// The buffer is not taken from the pool, since the data being decoded may still be backed by a pooled buffer
+	b := polyglot.NewBuffer()
+	encode(polyglot.Encoder(b))
+	d.hash.Write(b.Bytes())
+	d.size += uint32(len(b.Bytes()))
+}
+
+// SkipBytes skips over a byte array without reading it into memory, seeking past it if the underlying
+// reader can seek. It must not be used while hashing, since the skipped bytes are not hashed.
+func (d *streamDecoder) SkipBytes() error {
+	err := d.kind(polyglot.BytesKind, polyglot.InvalidBytes)
+	if err != nil {
+		return err
+	}
+	size, err := d.Uint32()
+	if err != nil {
+		return err
+	}
+
+	buffered := d.r.Buffered()
+	if d.seeker == nil || int(size) <= buffered {
+		_, err = d.r.Discard(int(size))
+		if errors.Is(err, io.EOF) {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	_, _ = d.r.Discard(buffered)
+	_, err = d.seeker.Seek(int64(size)-int64(buffered), io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	d.r.Reset(d.seeker)
+	return nil
+}
+
+// readBytes reads a byte array of the given size, only allocating memory for the data that is actually read
+func (d *streamDecoder) readBytes(size uint32) ([]byte, error) {
+	b := make([]byte, 0, min(size, maxPreallocation))
+	for uint32(len(b)) < size {
+		if len(b) == cap(b) {
+			b = append(b, 0)[:len(b)]
+		}
+		n := min(uint32(cap(b)), size) - uint32(len(b))
+		err := d.read(b[len(b) : len(b)+int(n)])
+		if err != nil {
+			return nil, err
+		}
+		b = b[:len(b)+int(n)]
+	}
+	return b, nil
+}
@@ -0,0 +1,110 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2022 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scalefunc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/loopholelabs/scale/extension"
+	"github.com/loopholelabs/scale/signature"
+)
+
+func TestDecodeFrom(t *testing.T) {
+	extensionSchema := new(extension.Schema)
+	require.NoError(t, extensionSchema.Decode([]byte(extension.MasterTestingSchema)))
+
+	s := testSchema(t)
+	s.Extensions = []V1BetaExtension{{
+		Name:         "Test Extension",
+		Organization: "Test Organization",
+		Tag:          "Test Tag",
+		Schema:       extensionSchema,
+		Hash:         "Test Extension Hash",
+	}}
+	s.Manifest = []byte("Test Manifest")
+	s.Stateless = true
+	s.Function = make([]byte, 1<<20)
+	_, err := rand.Read(s.Function)
+	require.NoError(t, err)
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	require.NoError(t, Sign(s, private))
+
+	encoded := testEncode(t, s)
+	expected := new(V1BetaSchema)
+	require.NoError(t, expected.Decode(encoded))
+
+	decoded := new(V1BetaSchema)
+	require.NoError(t, decoded.DecodeFrom(bytes.NewReader(encoded)))
+	assert.Equal(t, expected, decoded)
+
+	// The reader does not have to be able to seek
+	decoded = new(V1BetaSchema)
+	require.NoError(t, decoded.DecodeFrom(io.MultiReader(bytes.NewReader(encoded))))
+	assert.Equal(t, expected, decoded)
+
+	path := filepath.Join(t.TempDir(), "test.scale")
+	require.NoError(t, Write(path, s))
+
+	read, err := Read(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected, read)
+
+	metadata, err := ReadMetadata(path)
+	require.NoError(t, err)
+	assert.Nil(t, metadata.Function)
+	expected.Function = nil
+	assert.Equal(t, expected, metadata)
+
+	corrupted := bytes.Clone(encoded)
+	corrupted[len(corrupted)/2] ^= 0xff
+	assert.ErrorIs(t, new(V1BetaSchema).DecodeFrom(bytes.NewReader(corrupted)), ErrHash)
+
+	assert.ErrorIs(t, new(V1BetaSchema).DecodeFrom(bytes.NewReader(encoded[:len(encoded)/2])), io.ErrUnexpectedEOF)
+}
+
+func TestDecodeFromV1Alpha(t *testing.T) {
+	masterTestingSchema := new(signature.Schema)
+	require.NoError(t, masterTestingSchema.Decode([]byte(signature.MasterTestingSchema)))
+
+	v1Alpha := &V1AlphaSchema{
+		Name:            "Test Name",
+		Tag:             "Test Tag",
+		SignatureName:   "Test Signature",
+		SignatureSchema: masterTestingSchema,
+		Language:        Go,
+		Function:        []byte("Test Function Contents"),
+	}
+
+	expected := new(V1BetaSchema)
+	require.NoError(t, expected.Decode(v1Alpha.Encode()))
+
+	decoded := new(V1BetaSchema)
+	require.NoError(t, decoded.DecodeFrom(bytes.NewReader(v1Alpha.Encode())))
+	assert.Equal(t, expected, decoded)
+}
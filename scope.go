@@ -0,0 +1,97 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+
+	extension "github.com/loopholelabs/scale-extension-interfaces"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// ScopedExtension is an extension whose state is scoped to a single call to Instance.Run
+//
+// Extensions that do not implement ScopedExtension have Reset called before every call, which
+// discards the state of every other call that is running at the same time. Scoped extensions instead
+// keep their state per scope, which is available through the ExtensionMemory passed to their functions,
+// and have Release called with the scope once the call has completed.
+type ScopedExtension interface {
+	extension.Extension
+
+	// Release discards the state created during the call with the given scope
+	Release(scope uint64)
+}
+
+// ExtensionMemory is the extension.ModuleMemory passed to the functions of extensions,
+// which identifies the call and the module that the function was called from
+type ExtensionMemory interface {
+	extension.ModuleMemory
+
+	// Scope returns the identifier of the call to Instance.Run that the function was called from,
+	// which is unique within the runtime
+	Scope() uint64
+
+	// InstanceID returns the hex encoded identifier of the Instance that the function was called from
+	InstanceID() string
+
+	// Module returns the name of the wasm module that called the function
+	Module() string
+}
+
+var _ ExtensionMemory = (*extensionMemory)(nil)
+
+type extensionMemory struct {
+	api.Memory
+	scope      uint64
+	instanceID string
+	module     string
+}
+
+func (m *extensionMemory) Scope() uint64 {
+	return m.scope
+}
+
+func (m *extensionMemory) InstanceID() string {
+	return m.instanceID
+}
+
+func (m *extensionMemory) Module() string {
+	return m.module
+}
+
+type scopeKey struct{}
+
+// withScope returns a context for a call to Instance.Run with a new scope, along with the scope
+func (r *Scale[T]) withScope(ctx context.Context) (context.Context, uint64) {
+	scope := r.scopes.Add(1)
+	return context.WithValue(ctx, scopeKey{}, scope), scope
+}
+
+// scope returns the scope of the call to Instance.Run that the given context belongs to
+func scope(ctx context.Context) uint64 {
+	s, _ := ctx.Value(scopeKey{}).(uint64)
+	return s
+}
+
+// releaseExtensions releases the state created by scoped extensions during the call with the given scope
+func (r *Scale[T]) releaseExtensions(scope uint64) {
+	for _, ext := range r.config.extensions {
+		if scoped, ok := ext.(ScopedExtension); ok {
+			scoped.Release(scope)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/loopholelabs/wasm-toolkit/pkg/wasm/wasmfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	extension "github.com/loopholelabs/scale-extension-interfaces"
+
+	"github.com/loopholelabs/scale/scalefunc"
+)
+
+// testExtensionGuest returns a stateless scale function built from testdata/extension.wat,
+// which calls the `ext_test_Call` extension function with its input before echoing it back
+func testExtensionGuest(t testing.TB, name string) *scalefunc.V1BetaSchema {
+	t.Helper()
+
+	wf, err := wasmfile.NewFromWat("testdata/extension.wat")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, wf.EncodeBinary(&buf))
+
+	return &scalefunc.V1BetaSchema{
+		Name:      name,
+		Tag:       "latest",
+		Language:  scalefunc.Go,
+		Stateless: true,
+		Function:  buf.Bytes(),
+	}
+}
+
+// testExtension keeps the number of calls made with every input as its state
+type testExtension struct {
+	mu     sync.Mutex
+	calls  map[uint64]map[string]int
+	memory []ExtensionMemory
+	resets int
+
+	// onCall is called after every call has been recorded
+	onCall func(scope uint64, input string)
+}
+
+func newTestExtension() *testExtension {
+	return &testExtension{calls: make(map[uint64]map[string]int)}
+}
+
+func (e *testExtension) Init() map[string]extension.InstallableFunc {
+	return map[string]extension.InstallableFunc{
+		"ext_test_Call": func(mem extension.ModuleMemory, _ extension.Resizer, params []uint64) {
+			data, _ := mem.Read(uint32(params[1]), uint32(params[2]))
+			scoped := mem.(ExtensionMemory)
+
+			e.mu.Lock()
+			e.memory = append(e.memory, scoped)
+			if e.calls[scoped.Scope()] == nil {
+				e.calls[scoped.Scope()] = make(map[string]int)
+			}
+			e.calls[scoped.Scope()][string(data)]++
+			e.mu.Unlock()
+
+			if e.onCall != nil {
+				e.onCall(scoped.Scope(), string(data))
+			}
+		},
+	}
+}
+
+// count returns the number of calls made with the given input in the given scope
+func (e *testExtension) count(scope uint64, input string) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.calls[scope][input]
+}
+
+func (e *testExtension) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.resets++
+	e.calls = make(map[uint64]map[string]int)
+}
+
+type scopedTestExtension struct {
+	*testExtension
+	released []uint64
+}
+
+func (e *scopedTestExtension) Release(scope uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.released = append(e.released, scope)
+	delete(e.calls, scope)
+}
+
+func TestExtensionMemory(t *testing.T) {
+	ext := newTestExtension()
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testExtensionGuest(t, "first")).
+		WithExtension(ext))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		output, err := runTestInstance(t, instance, "echo")
+		require.NoError(t, err)
+		assert.Equal(t, "echo", output)
+	}
+
+	// Extensions that are not scoped are reset before every call
+	assert.Equal(t, 2, ext.resets)
+	require.Len(t, ext.memory, 2)
+	assert.NotEqual(t, ext.memory[0].Scope(), ext.memory[1].Scope())
+	for _, mem := range ext.memory {
+		assert.Equal(t, hex.EncodeToString(instance.identifier), mem.InstanceID())
+		assert.True(t, strings.HasPrefix(mem.Module(), "first:latest."))
+	}
+
+	require.NoError(t, r.Close(context.Background()))
+}
+
+func TestScopedExtension(t *testing.T) {
+	for _, scoped := range []bool{true, false} {
+		base := newTestExtension()
+		var ext extension.Extension = base
+		scopedExt := &scopedTestExtension{testExtension: base}
+		if scoped {
+			ext = scopedExt
+		}
+
+		r := newTestScale(t, NewConfig(newTestSignature).
+			WithFunction(testExtensionGuest(t, "first")).
+			WithExtension(ext))
+
+		first, err := r.Instance()
+		require.NoError(t, err)
+		second, err := r.Instance()
+		require.NoError(t, err)
+
+		// The call to the second instance runs while the call to the first instance is in progress
+		var outerCalls int
+		base.onCall = func(scope uint64, input string) {
+			if input == "outer" {
+				_, err := runTestInstance(t, second, "inner")
+				assert.NoError(t, err)
+				outerCalls = base.count(scope, "outer")
+			}
+		}
+
+		_, err = runTestInstance(t, first, "outer")
+		require.NoError(t, err)
+
+		if scoped {
+			// The state of the first call is not affected by the second call, and both are released once they complete
+			assert.Equal(t, 1, outerCalls)
+			assert.Zero(t, base.resets)
+			require.Len(t, scopedExt.released, 2)
+			assert.Equal(t, base.memory[1].Scope(), scopedExt.released[0])
+			assert.Equal(t, base.memory[0].Scope(), scopedExt.released[1])
+			assert.Empty(t, base.calls)
+		} else {
+			// Resetting the extension for the second call discards the state of the first call
+			assert.Zero(t, outerCalls)
+			assert.Equal(t, 2, base.resets)
+		}
+
+		require.NoError(t, r.Close(context.Background()))
+	}
+}
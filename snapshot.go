@@ -0,0 +1,256 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/loopholelabs/polyglot"
+	"github.com/tetratelabs/wazero/api"
+)
+
+const (
+	snapshotVersion = "v1"
+)
+
+var (
+	ErrInvalidSnapshot   = errors.New("invalid snapshot")
+	ErrSnapshotsDisabled = errors.New("snapshots are not enabled")
+)
+
+// functionState is the state of a single stateful function in a snapshot
+type functionState struct {
+	hash       string
+	identifier string
+	memory     []byte
+	globals    []uint64
+}
+
+// Snapshot serializes the state (the linear memory and mutable globals) of every stateful
+// function in the instance, so that it can be restored later on using Scale.RestoreInstance,
+// possibly by a different process or host.
+//
+// The state of each function is keyed by the hash of the function, and stateless functions
+// are not included. Snapshot returns ErrInstanceBusy if the instance is running, and
+// ErrSnapshotsDisabled if snapshots were not enabled using Config.WithSnapshots.
+func (i *Instance[T]) Snapshot() ([]byte, error) {
+	if !i.runtime.config.snapshots {
+		return nil, ErrSnapshotsDisabled
+	}
+
+	unlock, err := i.tryLock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	functions := i.statefulFunctions()
+
+	b := polyglot.GetBuffer()
+	defer polyglot.PutBuffer(b)
+	e := polyglot.Encoder(b)
+	e.String(snapshotVersion)
+	e.Slice(uint32(len(functions)), polyglot.AnyKind)
+	for _, fn := range functions {
+		m := fn.module
+		if m.closed() {
+			return nil, fmt.Errorf("failed to snapshot function '%s': %w", fn.template.identifier, errModuleClosed)
+		}
+
+		var memory []byte
+		if mem := m.instantiatedModule.Memory(); mem != nil {
+			var ok bool
+			memory, ok = mem.Read(0, mem.Size())
+			if !ok {
+				return nil, fmt.Errorf("failed to read memory for function '%s'", fn.template.identifier)
+			}
+		}
+
+		e.String(fn.template.hash)
+		e.String(fn.template.identifier)
+		e.Bytes(memory)
+		e.Slice(uint32(len(fn.template.globals)), polyglot.Uint64Kind)
+		for _, name := range fn.template.globals {
+			e.Uint64(m.instantiatedModule.ExportedGlobal(name).Get())
+		}
+	}
+
+	return append([]byte{}, b.Bytes()...), nil
+}
+
+// RestoreInstance returns a new instance of the Scale Function chain with the provided and optional next function,
+// and restores the state of its stateful functions from a snapshot created with Instance.Snapshot.
+//
+// Every stateful function in the chain must have its state in the snapshot, and the snapshot
+// must not contain the state of functions that are not in the chain. Snapshots must be enabled
+// using Config.WithSnapshots, otherwise ErrSnapshotsDisabled is returned.
+func (r *Scale[T]) RestoreInstance(snapshot []byte, next ...Next[T]) (_ *Instance[T], err error) {
+	if !r.config.snapshots {
+		return nil, ErrSnapshotsDisabled
+	}
+
+	states, err := decodeSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	instance, err := r.Instance(next...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			instance.Close()
+		}
+	}()
+
+	functions := instance.statefulFunctions()
+	restored := make([]functionState, len(functions))
+	for i, fn := range functions {
+		candidates := states[fn.template.hash]
+		if len(candidates) == 0 {
+			return nil, fmt.Errorf("%w: no state for function '%s'", ErrInvalidSnapshot, fn.template.identifier)
+		}
+		restored[i] = candidates[0]
+		states[fn.template.hash] = candidates[1:]
+	}
+
+	for _, candidates := range states {
+		if len(candidates) > 0 {
+			return nil, fmt.Errorf("%w: function '%s' is not part of the chain", ErrInvalidSnapshot, candidates[0].identifier)
+		}
+	}
+
+	for i, fn := range functions {
+		err = fn.module.restore(restored[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore function '%s': %w", fn.template.identifier, err)
+		}
+	}
+
+	return instance, nil
+}
+
+// statefulFunctions returns every function in the instance that has its own module,
+// including the functions in fan-outs
+func (i *Instance[T]) statefulFunctions() []*function[T] {
+	var functions []*function[T]
+	for fn := i.head; fn != nil; fn = fn.next {
+		for _, f := range append([]*function[T]{fn}, fn.branches...) {
+			if f.module != nil {
+				functions = append(functions, f)
+			}
+		}
+	}
+	return functions
+}
+
+// restore overwrites the memory and mutable globals of the module with the given state
+func (m *module[T]) restore(state functionState) error {
+	if len(state.globals) != len(m.template.globals) {
+		return fmt.Errorf("%w: expected %d globals, got %d", ErrInvalidSnapshot, len(m.template.globals), len(state.globals))
+	}
+
+	mem := m.instantiatedModule.Memory()
+	if mem == nil {
+		if len(state.memory) > 0 {
+			return fmt.Errorf("%w: function does not have a memory", ErrInvalidSnapshot)
+		}
+	} else {
+		size := mem.Size()
+		if uint32(len(state.memory)) > size {
+			_, ok := mem.Grow((uint32(len(state.memory)) - size + memoryPageSize - 1) / memoryPageSize)
+			if !ok {
+				return fmt.Errorf("%w: failed to grow memory to %d bytes", ErrInvalidSnapshot, len(state.memory))
+			}
+		}
+		if !mem.Write(0, state.memory) {
+			return fmt.Errorf("failed to write memory")
+		}
+		if size > uint32(len(state.memory)) && !mem.Write(uint32(len(state.memory)), make([]byte, size-uint32(len(state.memory)))) {
+			return fmt.Errorf("failed to clear memory")
+		}
+	}
+
+	for i, name := range m.template.globals {
+		global, ok := m.instantiatedModule.ExportedGlobal(name).(api.MutableGlobal)
+		if !ok {
+			return fmt.Errorf("global '%s' is not mutable", name)
+		}
+		global.Set(state.globals[i])
+	}
+
+	return nil
+}
+
+// decodeSnapshot decodes the function states in a snapshot, grouped by function hash
+func decodeSnapshot(snapshot []byte) (map[string][]functionState, error) {
+	d := polyglot.GetDecoder(snapshot)
+	defer d.Return()
+
+	version, err := d.String()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+	if version != snapshotVersion {
+		return nil, fmt.Errorf("%w: unknown version '%s'", ErrInvalidSnapshot, version)
+	}
+
+	count, err := d.Slice(polyglot.AnyKind)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+	}
+
+	states := make(map[string][]functionState)
+	for i := uint32(0); i < count; i++ {
+		state, err := decodeFunctionState(d)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSnapshot, err)
+		}
+		states[state.hash] = append(states[state.hash], state)
+	}
+
+	return states, nil
+}
+
+func decodeFunctionState(d *polyglot.Decoder) (state functionState, err error) {
+	state.hash, err = d.String()
+	if err != nil {
+		return
+	}
+	state.identifier, err = d.String()
+	if err != nil {
+		return
+	}
+	state.memory, err = d.Bytes(nil)
+	if err != nil {
+		return
+	}
+	count, err := d.Slice(polyglot.Uint64Kind)
+	if err != nil {
+		return
+	}
+	state.globals = make([]uint64, count)
+	for i := range state.globals {
+		state.globals[i], err = d.Uint64()
+		if err != nil {
+			return
+		}
+	}
+	return
+}
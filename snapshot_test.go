@@ -0,0 +1,95 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	config := func() *Config[*testSignature] {
+		return NewConfig(newTestSignature).
+			WithFunction(testGuest(t, "first", false)).
+			WithFunction(testGuest(t, "second", true)).
+			WithSnapshots(true)
+	}
+
+	r := newTestScale(t, config())
+	instance, err := r.Instance()
+	require.NoError(t, err)
+	require.Equal(t, []string{"scale_global_0", "scale_global_1", "scale_global_2"}, instance.head.template.globals)
+
+	for i := 1; i <= 2; i++ {
+		output, err := runTestInstance(t, instance, "c-")
+		require.NoError(t, err)
+		require.Equal(t, byte(i), output[1])
+	}
+
+	snapshot, err := instance.Snapshot()
+	require.NoError(t, err)
+
+	restoredRuntime := newTestScale(t, config())
+	restored, err := restoredRuntime.RestoreInstance(snapshot)
+	require.NoError(t, err)
+
+	memory := func(instance *Instance[*testSignature]) []byte {
+		mem := instance.head.module.instantiatedModule.Memory()
+		buf, ok := mem.Read(0, mem.Size())
+		require.True(t, ok)
+		return buf
+	}
+	assert.Equal(t, memory(instance), memory(restored))
+
+	output, err := runTestInstance(t, restored, "c-")
+	require.NoError(t, err)
+	assert.Equal(t, byte(3), output[1])
+
+	output, err = runTestInstance(t, instance, "c-")
+	require.NoError(t, err)
+	assert.Equal(t, byte(3), output[1])
+
+	other := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)).
+		WithFunction(testGuest(t, "third", false)).
+		WithSnapshots(true))
+	_, err = other.RestoreInstance(snapshot)
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	// The instance that could not be restored is closed
+	other.activeModulesMu.RLock()
+	assert.Empty(t, other.activeModules)
+	other.activeModulesMu.RUnlock()
+
+	_, err = restoredRuntime.RestoreInstance([]byte("invalid"))
+	assert.ErrorIs(t, err, ErrInvalidSnapshot)
+
+	disabled := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)))
+	_, err = disabled.RestoreInstance(snapshot)
+	assert.ErrorIs(t, err, ErrSnapshotsDisabled)
+
+	instance, err = disabled.Instance()
+	require.NoError(t, err)
+	assert.Empty(t, instance.head.template.globals)
+	_, err = instance.Snapshot()
+	assert.ErrorIs(t, err, ErrSnapshotsDisabled)
+}
@@ -150,7 +150,11 @@ func (s *FunctionStorage) Get(name string, tag string, org string, hash string)
 func (s *FunctionStorage) Put(name string, tag string, org string, sf *scalefunc.V1BetaSchema) error {
 	f := s.functionName(name, tag, org, hex.EncodeToString(sf.GetHash()))
 	p := s.fullPath(f)
-	return os.WriteFile(p, sf.Encode(), 0644)
+	encoded, err := sf.Encode()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, encoded, 0644)
 }
 
 // Delete removes the Scale Function with the given name, tag, org, and hash
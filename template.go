@@ -18,11 +18,17 @@ package scale
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"sync/atomic"
 
 	"github.com/tetratelabs/wazero"
 
 	interfaces "github.com/loopholelabs/scale-signature-interfaces"
+	"github.com/loopholelabs/scale/log"
 	"github.com/loopholelabs/scale/scalefunc"
 )
 
@@ -31,46 +37,140 @@ type template[T interfaces.Signature] struct {
 	// runtime is the scale runtime that the template belongs to
 	runtime *Scale[T]
 
+	// name is the name of the function the template was created from
+	name string
+
+	// tag is the tag of the function the template was created from
+	tag string
+
 	// identifier is the identifier for the template
 	identifier string
 
+	// hash is the hex encoded hash of the function the template was created from
+	hash string
+
+	// globals are the export names of the mutable globals of stateful functions,
+	// which are exported so that they can be included in snapshots
+	globals []string
+
 	// compiled is the compiled module source
 	compiled wazero.CompiledModule
 
-	// next is the (optional) next function in the chain
-	next *template[T]
-
 	// modulePool is the pool of modules for the template
 	modulePool *modulePool[T]
 
-	// env contains the (optional) environment variables for the template
-	env map[string]string
+	// config is the per-function configuration for the template
+	config *FunctionConfig
+
+	// branches are the templates that run concurrently when the template is a fan-out,
+	// and merge combines their results
+	//
+	// Fan-out templates do not have a compiled module, module pool, or config
+	branches []*template[T]
+	merge    Merge[T]
+
+	// instances is the number of instances using the template, which is closed once it has
+	// been removed from the function chain and is no longer used by any instance
+	instances atomic.Int64
 }
 
 // newTemplate creates a new template from a scale function schema
-func newTemplate[T interfaces.Signature](ctx context.Context, runtime *Scale[T], scaleFunc *scalefunc.V1BetaSchema, env map[string]string, opts ...uint32) (*template[T], error) {
-	compiled, err := runtime.runtime.CompileModule(ctx, scaleFunc.Function)
-	if err != nil {
-		return nil, fmt.Errorf("failed to compile wasm module '%s': %w", scaleFunc.Name, err)
+func newTemplate[T interfaces.Signature](ctx context.Context, runtime *Scale[T], scaleFunc *scalefunc.V1BetaSchema, config *FunctionConfig) (*template[T], error) {
+	if config == nil {
+		config = NewFunctionConfig()
+	}
+
+	binary := scaleFunc.Function
+	if config.maxMemoryPages > 0 {
+		var err error
+		binary, err = limitMemoryPages(binary, config.maxMemoryPages)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply memory limit to wasm module '%s': %w", scaleFunc.Name, err)
+		}
 	}
 
-	var maxSize uint32
-	if len(opts) > 0 {
-		maxSize = opts[0]
-	} else {
-		maxSize = 0 // default value
+	var globals []string
+	if !scaleFunc.Stateless && runtime.config.snapshots {
+		var err error
+		binary, globals, err = exportMutableGlobals(binary)
+		if err != nil {
+			return nil, fmt.Errorf("failed to export globals of wasm module '%s': %w", scaleFunc.Name, err)
+		}
+	}
+
+	compiled, err := runtime.compile(ctx, binary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile wasm module '%s': %w", scaleFunc.Name, err)
 	}
 
 	templ := &template[T]{
 		runtime:    runtime,
+		name:       scaleFunc.Name,
+		tag:        scaleFunc.Tag,
 		identifier: fmt.Sprintf("%s:%s", scaleFunc.Name, scaleFunc.Tag),
+		hash:       functionHash(scaleFunc),
+		globals:    globals,
 		compiled:   compiled,
-		env:        env,
+		config:     config,
 	}
 
 	if scaleFunc.Stateless {
-		templ.modulePool = newModulePool[T](ctx, templ, maxSize)
+		templ.modulePool, err = newModulePool[T](ctx, templ)
+		if err != nil {
+			_ = runtime.closeCompiled(ctx, compiled)
+			return nil, err
+		}
 	}
 
 	return templ, nil
 }
+
+// functionHash returns the hash of the given scale function, or the hash of
+// its wasm binary if the scale function was not encoded (and therefore not hashed)
+func functionHash(scaleFunc *scalefunc.V1BetaSchema) string {
+	if scaleFunc.Hash != "" {
+		return scaleFunc.Hash
+	}
+	hash := sha256.Sum256(scaleFunc.Function)
+	return hex.EncodeToString(hash[:])
+}
+
+// output returns the writer for the given output stream of a module with the given name,
+// along with the structured logger backing the writer if there is one
+//
+// Writers configured for the function take precedence over the log handler,
+// which takes precedence over the writers configured for the runtime
+func (t *template[T]) output(name string, stream log.Stream) (io.Writer, *log.Logger) {
+	w, fallback := t.config.stdout, t.runtime.config.stdout
+	if stream == log.Stderr {
+		w, fallback = t.config.stderr, t.runtime.config.stderr
+	}
+
+	if w == nil {
+		if t.runtime.config.logHandler != nil {
+			logger := log.NewLogger(t.runtime.config.logHandler, t.name, t.tag, stream)
+			return logger, logger
+		}
+		w = fallback
+	}
+
+	if w != nil && !t.runtime.config.rawOutput {
+		w = log.NewNamedLogger(name, w)
+	}
+	return w, nil
+}
+
+// close closes the module pool and compiled module of the template, or of its branches if it is a fan-out
+func (t *template[T]) close(ctx context.Context) error {
+	var errs []error
+	for _, b := range flattenTemplates([]*template[T]{t}) {
+		if b.modulePool != nil {
+			b.modulePool.Close()
+		}
+		err := b.runtime.closeCompiled(ctx, b.compiled)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to close compiled module for function '%s': %w", b.identifier, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,35 @@
+(module
+  (type (;0;) (func (param i64 i32 i32) (result i64)))
+  (type (;1;) (func (param i32) (result i32)))
+  (type (;2;) (func (result i64)))
+  (import "env" "ext_test_Call" (func (type 0)))
+  (memory (;0;) 2)
+  (global (;0;) (mut i32) (i32.const 1024))
+  (global (;1;) (mut i32) (i32.const 0))
+  (export "memory" (memory 0))
+  (export "resize" (func 1))
+  (export "initialize" (func 2))
+  (export "run" (func 3))
+  (func (;1;) (type 1) (param i32) (result i32)
+    local.get 0
+    global.set 1
+    global.get 0
+  )
+  (func (;2;) (type 2) (result i64)
+    i64.const 0
+  )
+  (func (;3;) (type 2) (result i64)
+    i64.const 0
+    global.get 0
+    global.get 1
+    call 0
+    drop
+    global.get 0
+    i64.extend_i32_u
+    i64.const 32
+    i64.shl
+    global.get 1
+    i64.extend_i32_u
+    i64.or
+  )
+)
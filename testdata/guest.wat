@@ -0,0 +1,142 @@
+(module
+  (type (;0;) (func (param i32 i32)))
+  (type (;1;) (func (param i32) (result i32)))
+  (type (;2;) (func (result i64)))
+  (type (;3;) (func (param i32)))
+  (type (;4;) (func (param i32 i32 i32 i32) (result i32)))
+  (type (;5;) (func))
+  (import "env" "next" (func (type 0)))
+  (import "wasi_snapshot_preview1" "proc_exit" (func (type 3)))
+  (import "wasi_snapshot_preview1" "fd_write" (func (type 4)))
+  (memory (;0;) 2)
+  (global (;0;) (mut i32) (i32.const 1024))
+  (global (;1;) (mut i32) (i32.const 0))
+  (global (;2;) (mut i32) (i32.const 0))
+  (export "memory" (memory 0))
+  (export "resize" (func 3))
+  (export "initialize" (func 4))
+  (export "run" (func 7))
+  (func (;3;) (type 1) (param i32) (result i32)
+    local.get 0
+    global.set 1
+    global.get 0
+  )
+  (func (;4;) (type 2) (result i64)
+    i64.const 0
+  )
+  (func (;5;) (type 5)
+    call 5
+  )
+  (func (;6;) (type 2) (result i64)
+    global.get 0
+    i64.extend_i32_u
+    i64.const 32
+    i64.shl
+    global.get 1
+    i64.extend_i32_u
+    i64.or
+  )
+  (func (;7;) (type 2) (result i64)
+    (local i32)
+    global.get 0
+    i32.load8_u
+    local.set 0
+    local.get 0
+    i32.const 99
+    i32.eq
+    if
+      global.get 2
+      i32.const 1
+      i32.add
+      global.set 2
+      global.get 0
+      global.get 2
+      i32.store8 offset=1
+    end
+    local.get 0
+    i32.const 103
+    i32.eq
+    if
+      loop
+        i32.const 1
+        memory.grow
+        i32.const -1
+        i32.ne
+        br_if 0
+      end
+      unreachable
+    end
+    local.get 0
+    i32.const 108
+    i32.eq
+    if
+      loop
+        br 0
+      end
+    end
+    local.get 0
+    i32.const 110
+    i32.eq
+    if
+      global.get 0
+      global.get 1
+      call 0
+    end
+    local.get 0
+    i32.const 111
+    i32.eq
+    if
+      i32.const -1
+      i32.load
+      drop
+    end
+    local.get 0
+    i32.const 112
+    i32.eq
+    if
+      i32.const 0
+      global.get 0
+      i32.const 1
+      i32.add
+      i32.store
+      i32.const 4
+      global.get 1
+      i32.const 1
+      i32.sub
+      i32.store
+      i32.const 1
+      i32.const 0
+      i32.const 1
+      i32.const 8
+      call 2
+      drop
+    end
+    local.get 0
+    i32.const 115
+    i32.eq
+    if
+      call 5
+    end
+    local.get 0
+    i32.const 116
+    i32.eq
+    if
+      unreachable
+    end
+    local.get 0
+    i32.const 120
+    i32.eq
+    if
+      i32.const 3
+      call 1
+    end
+    local.get 0
+    i32.const 122
+    i32.eq
+    if
+      i64.const 0
+      return
+    end
+    call 6
+  )
+)
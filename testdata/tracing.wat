@@ -0,0 +1,56 @@
+(module
+  (type (;0;) (func (param i32 i32)))
+  (type (;1;) (func (param i32) (result i32)))
+  (type (;2;) (func (result i64)))
+  (import "env" "next" (func (type 0)))
+  (import "scale" "otel_trace_json" (func (type 0)))
+  (memory (;0;) 2)
+  (global (;0;) (mut i32) (i32.const 1024))
+  (global (;1;) (mut i32) (i32.const 0))
+  (export "memory" (memory 0))
+  (export "resize" (func 2))
+  (export "initialize" (func 3))
+  (export "run" (func 4))
+  (func (;2;) (type 1) (param i32) (result i32)
+    local.get 0
+    global.set 1
+    global.get 0
+  )
+  (func (;3;) (type 2) (result i64)
+    i64.const 0
+  )
+  (func (;4;) (type 2) (result i64)
+    (local i32)
+    global.get 0
+    i32.load8_u
+    local.set 0
+    global.get 0
+    i32.const 1
+    i32.add
+    global.get 1
+    i32.const 1
+    i32.sub
+    call 1
+    local.get 0
+    i32.const 110
+    i32.eq
+    if
+      global.get 0
+      global.get 1
+      call 0
+    end
+    local.get 0
+    i32.const 116
+    i32.eq
+    if
+      unreachable
+    end
+    global.get 0
+    i64.extend_i32_u
+    i64.const 32
+    i64.shl
+    global.get 1
+    i64.extend_i32_u
+    i64.or
+  )
+)
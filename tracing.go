@@ -18,8 +18,22 @@ package scale
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"time"
 
 	"github.com/tetratelabs/wazero/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// TracerName is the name of the tracer used by the Scale Runtime
+	TracerName = "github.com/loopholelabs/scale"
+
+	functionAttribute = "scale.function"
+	instanceAttribute = "scale.instance.id"
 )
 
 // getFunctionNameLen is the Host function for getting the Function Name Length
@@ -64,8 +78,11 @@ func (r *Scale[T]) getInstanceID(_ context.Context, module api.Module, params []
 
 // otelTraceJSON is the Host function to receive OTEL Trace data in JSON
 // and then call the TraceDataCallback
-func (r *Scale[T]) otelTraceJSON(_ context.Context, module api.Module, params []uint64) {
-	if r.TraceDataCallback == nil {
+//
+// If a TracerProvider was configured, the trace data is also decoded and
+// re-emitted as spans parented under the span of the calling function
+func (r *Scale[T]) otelTraceJSON(ctx context.Context, module api.Module, params []uint64) {
+	if r.TraceDataCallback == nil && r.config.tracerProvider == nil {
 		return
 	}
 
@@ -73,6 +90,91 @@ func (r *Scale[T]) otelTraceJSON(_ context.Context, module api.Module, params []
 	length := uint32(params[1])
 	mem := module.Memory()
 	if data, ok := mem.Read(ptr, length); ok {
-		r.TraceDataCallback(string(data))
+		if r.TraceDataCallback != nil {
+			r.TraceDataCallback(string(data))
+		}
+		if r.config.tracerProvider != nil {
+			_ = r.emitTraceData(ctx, data)
+		}
+	}
+}
+
+// startSpan starts the span for a single call to a function's `run` export
+func (r *Scale[T]) startSpan(ctx context.Context, m *module[T]) (context.Context, trace.Span) {
+	attributes := []attribute.KeyValue{
+		attribute.String(functionAttribute, m.template.identifier),
 	}
+	if m.function != nil {
+		attributes = append(attributes, attribute.String(instanceAttribute, hex.EncodeToString(m.function.instance.identifier)))
+	}
+	return r.tracer.Start(ctx, m.template.identifier, trace.WithAttributes(attributes...))
+}
+
+// endSpan ends the span for a single call to a function's `run` export
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// emitTraceData decodes OTLP JSON encoded trace data and re-emits the contained spans using the configured tracer
+//
+// Spans whose parents are not part of the same payload are parented under the span contained in ctx.
+func (r *Scale[T]) emitTraceData(ctx context.Context, data []byte) error {
+	request := new(otlpTraceData)
+	err := json.Unmarshal(data, request)
+	if err != nil {
+		return err
+	}
+
+	spans := make(map[string]*otlpSpan)
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for i := range scopeSpans.Spans {
+				spans[scopeSpans.Spans[i].SpanID] = &scopeSpans.Spans[i]
+			}
+		}
+	}
+
+	emitted := make(map[string]context.Context, len(spans))
+	var emit func(span *otlpSpan) context.Context
+	emit = func(span *otlpSpan) context.Context {
+		if spanCtx, ok := emitted[span.SpanID]; ok {
+			return spanCtx
+		}
+		// Mark the span as emitted before emitting its parent to guard against cycles
+		emitted[span.SpanID] = ctx
+
+		parentCtx := ctx
+		if parent, ok := spans[span.ParentSpanID]; ok && span.ParentSpanID != "" {
+			parentCtx = emit(parent)
+		}
+
+		spanCtx, s := r.tracer.Start(parentCtx, span.Name,
+			trace.WithSpanKind(trace.SpanKind(span.Kind)),
+			trace.WithTimestamp(time.Unix(0, int64(span.StartTimeUnixNano))),
+			trace.WithAttributes(span.Attributes.keyValues()...),
+		)
+		for _, event := range span.Events {
+			s.AddEvent(event.Name, trace.WithTimestamp(time.Unix(0, int64(event.TimeUnixNano))), trace.WithAttributes(event.Attributes.keyValues()...))
+		}
+		switch span.Status.Code {
+		case otlpStatusCodeOk:
+			s.SetStatus(codes.Ok, span.Status.Message)
+		case otlpStatusCodeError:
+			s.SetStatus(codes.Error, span.Status.Message)
+		}
+		s.End(trace.WithTimestamp(time.Unix(0, int64(span.EndTimeUnixNano))))
+
+		emitted[span.SpanID] = spanCtx
+		return spanCtx
+	}
+
+	for _, span := range spans {
+		emit(span)
+	}
+
+	return nil
 }
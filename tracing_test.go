@@ -0,0 +1,216 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/loopholelabs/wasm-toolkit/pkg/wasm/wasmfile"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	interfaces "github.com/loopholelabs/scale-signature-interfaces"
+
+	"github.com/loopholelabs/scale/scalefunc"
+)
+
+const testTraceData = `{
+	"resourceSpans": [{
+		"scopeSpans": [{
+			"spans": [
+				{
+					"traceId": "5b8efff798038103d269b633813fc60c",
+					"spanId": "eee19b7ec3c1b174",
+					"parentSpanId": "eee19b7ec3c1b173",
+					"name": "child",
+					"kind": 3,
+					"startTimeUnixNano": "1544712660300000000",
+					"endTimeUnixNano": "1544712660600000000",
+					"attributes": [
+						{"key": "string", "value": {"stringValue": "value"}},
+						{"key": "int", "value": {"intValue": "42"}},
+						{"key": "array", "value": {"arrayValue": {"values": [{"boolValue": true}, {"boolValue": false}]}}}
+					],
+					"status": {"code": 2, "message": "failed"}
+				},
+				{
+					"traceId": "5b8efff798038103d269b633813fc60c",
+					"spanId": "eee19b7ec3c1b173",
+					"name": "parent",
+					"kind": 1,
+					"startTimeUnixNano": 1544712660000000000,
+					"endTimeUnixNano": 1544712661000000000,
+					"events": [
+						{"timeUnixNano": "1544712660500000000", "name": "event", "attributes": [{"key": "double", "value": {"doubleValue": 1.5}}]}
+					]
+				}
+			]
+		}]
+	}]
+}`
+
+func TestEmitTraceData(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	r := &Scale[interfaces.Signature]{
+		tracer: provider.Tracer(TracerName),
+	}
+
+	ctx, root := r.tracer.Start(context.Background(), "root")
+	err := r.emitTraceData(ctx, []byte(testTraceData))
+	require.NoError(t, err)
+	root.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Len(t, spans, 3)
+
+	parent := spans["parent"]
+	assert.Equal(t, spans["root"].SpanContext().SpanID(), parent.Parent().SpanID())
+	assert.Equal(t, trace.SpanKindInternal, parent.SpanKind())
+	assert.Equal(t, time.Unix(0, 1544712660000000000), parent.StartTime())
+	assert.Equal(t, time.Unix(0, 1544712661000000000), parent.EndTime())
+	require.Len(t, parent.Events(), 1)
+	assert.Equal(t, "event", parent.Events()[0].Name)
+	assert.Equal(t, []attribute.KeyValue{attribute.Float64("double", 1.5)}, parent.Events()[0].Attributes)
+
+	child := spans["child"]
+	assert.Equal(t, parent.SpanContext().SpanID(), child.Parent().SpanID())
+	assert.Equal(t, parent.SpanContext().TraceID(), child.SpanContext().TraceID())
+	assert.Equal(t, trace.SpanKindClient, child.SpanKind())
+	assert.Equal(t, codes.Error, child.Status().Code)
+	assert.Equal(t, "failed", child.Status().Description)
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("string", "value"),
+		attribute.Int64("int", 42),
+		attribute.BoolSlice("array", []bool{true, false}),
+	}, child.Attributes())
+
+	err = r.emitTraceData(ctx, []byte("invalid"))
+	assert.Error(t, err)
+}
+
+// testTracingGuest returns a stateless scale function built from testdata/tracing.wat,
+// which sends everything after the first byte of its input as trace data
+//
+// The first byte of the input then selects what the guest does before echoing its input back:
+//
+//	n - call the `next` host function with the input
+//	t - trap with an unreachable instruction
+func testTracingGuest(t testing.TB, name string) *scalefunc.V1BetaSchema {
+	t.Helper()
+
+	wf, err := wasmfile.NewFromWat("testdata/tracing.wat")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	require.NoError(t, wf.EncodeBinary(&buf))
+
+	return &scalefunc.V1BetaSchema{
+		Name:      name,
+		Tag:       "latest",
+		Language:  scalefunc.Go,
+		Stateless: true,
+		Function:  buf.Bytes(),
+	}
+}
+
+func TestRunTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testTracingGuest(t, "first")).
+		WithFunction(testGuest(t, "second", true)).
+		WithTracerProvider(provider))
+
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	ctx, root := provider.Tracer("test").Start(context.Background(), "root")
+	sig := newTestSignature()
+	sig.data = []byte("n" + testTraceData)
+	require.NoError(t, instance.Run(ctx, sig))
+
+	sig = newTestSignature()
+	sig.data = []byte("t" + testTraceData)
+	require.Error(t, instance.Run(ctx, sig))
+	root.End()
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	children := make(map[trace.SpanID][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+		children[span.Parent().SpanID()] = append(children[span.Parent().SpanID()], span)
+	}
+
+	// Every call to a function has its own span under the span of its caller
+	require.Len(t, spans["first:latest"], 2)
+	require.Len(t, spans["second:latest"], 1)
+	rootID := spans["root"][0].SpanContext().SpanID()
+	require.Len(t, children[rootID], 2)
+
+	var succeeded, failed sdktrace.ReadOnlySpan
+	for _, span := range children[rootID] {
+		assert.Equal(t, "first:latest", span.Name())
+		assert.Contains(t, span.Attributes(), attribute.String(functionAttribute, "first:latest"))
+		if span.Status().Code == codes.Error {
+			failed = span
+		} else {
+			succeeded = span
+		}
+	}
+	require.NotNil(t, succeeded)
+	require.NotNil(t, failed)
+	assert.Equal(t, codes.Unset, succeeded.Status().Code)
+	assert.Contains(t, failed.Status().Description, "unreachable")
+
+	second := spans["second:latest"][0]
+	assert.Equal(t, succeeded.SpanContext().SpanID(), second.Parent().SpanID())
+	assert.Equal(t, codes.Unset, second.Status().Code)
+
+	// The spans sent by the guest end up under the span of the call that sent them,
+	// even when that call fails afterwards
+	for _, function := range []sdktrace.ReadOnlySpan{succeeded, failed} {
+		var parent sdktrace.ReadOnlySpan
+		for _, span := range children[function.SpanContext().SpanID()] {
+			if span.Name() == "parent" {
+				parent = span
+			}
+		}
+		require.NotNil(t, parent)
+		assert.Equal(t, codes.Unset, parent.Status().Code)
+
+		require.Len(t, children[parent.SpanContext().SpanID()], 1)
+		child := children[parent.SpanContext().SpanID()][0]
+		assert.Equal(t, "child", child.Name())
+		assert.Equal(t, codes.Error, child.Status().Code)
+		assert.Equal(t, "failed", child.Status().Description)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/sys"
+
+	interfaces "github.com/loopholelabs/scale-signature-interfaces"
+)
+
+const (
+	wasmStackTraceHeader = "\nwasm stack trace:\n"
+)
+
+var (
+	// trapProbe is a wasm module whose exports trap with the given TrapKinds, and is used to retrieve
+	// the errors of the wasm runtime for each TrapKind since they are not exported by wazero
+	//
+	//	(module
+	//	  (memory 0)
+	//	  (func (export "unreachable") unreachable)
+	//	  (func (export "out of bounds memory access") i32.const 0 i32.load drop)
+	//	  (func (export "stack overflow") call 2))
+	trapProbe = []byte{
+		0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+		0x01, 0x04, 0x01, 0x60, 0x00, 0x00,
+		0x03, 0x04, 0x03, 0x00, 0x00, 0x00,
+		0x05, 0x03, 0x01, 0x00, 0x00,
+		0x07, 0x3e, 0x03,
+		0x0b, 'u', 'n', 'r', 'e', 'a', 'c', 'h', 'a', 'b', 'l', 'e', 0x00, 0x00,
+		0x1b, 'o', 'u', 't', ' ', 'o', 'f', ' ', 'b', 'o', 'u', 'n', 'd', 's', ' ', 'm', 'e', 'm', 'o', 'r', 'y', ' ', 'a', 'c', 'c', 'e', 's', 's', 0x00, 0x01,
+		0x0e, 's', 't', 'a', 'c', 'k', ' ', 'o', 'v', 'e', 'r', 'f', 'l', 'o', 'w', 0x00, 0x02,
+		0x0a, 0x13, 0x03,
+		0x03, 0x00, 0x00, 0x0b,
+		0x08, 0x00, 0x41, 0x00, 0x28, 0x02, 0x00, 0x1a, 0x0b,
+		0x04, 0x00, 0x10, 0x02, 0x0b,
+	}
+
+	trapErrorsOnce sync.Once
+	trapErrors     map[error]TrapKind
+)
+
+// TrapKind is the kind of failure that caused a guest function to stop running
+type TrapKind string
+
+const (
+	// TrapKindNone means the function did not trap, but its `run` export reported a failure
+	TrapKindNone TrapKind = "none"
+
+	// TrapKindUnknown means the function trapped for a reason that is not covered by any other TrapKind
+	TrapKindUnknown TrapKind = "unknown"
+
+	// TrapKindUnreachable means the function executed an `unreachable` instruction,
+	// which is how most languages implement panics and aborts
+	TrapKindUnreachable TrapKind = "unreachable"
+
+	// TrapKindOutOfBounds means the function tried to access memory outside its linear memory
+	TrapKindOutOfBounds TrapKind = "out of bounds memory access"
+
+	// TrapKindStackOverflow means the function exceeded the maximum call stack depth
+	TrapKindStackOverflow TrapKind = "stack overflow"
+
+	// TrapKindExit means the function exited (usually through the WASI `proc_exit` function),
+	// in which case GuestError.ExitCode contains its exit code
+	TrapKindExit TrapKind = "exit"
+)
+
+// GuestError is returned when a guest function traps, exits, or otherwise fails while running
+//
+// It can be retrieved from the errors returned by Instance.Run using errors.As.
+type GuestError struct {
+	// Function is the identifier (`<name>:<tag>`) of the function that failed
+	Function string
+
+	// InstanceID is the hex encoded identifier of the Instance the function belongs to
+	InstanceID string
+
+	// Kind is the kind of failure
+	Kind TrapKind
+
+	// ExitCode is the exit code of the function if Kind is TrapKindExit
+	ExitCode uint32
+
+	// Stack is the wasm stack trace at the time of the trap, innermost frame first
+	//
+	// Frames are symbolicated using the name section of the function's wasm module, if it has one,
+	// and use the function's index (`$<index>`) otherwise.
+	Stack []string
+
+	// Err is the underlying error returned by the wasm runtime, if any
+	Err error
+}
+
+// newGuestError creates a GuestError for the given module from the error returned
+// by the wasm runtime, which may be nil if the function failed without trapping
+func newGuestError[T interfaces.Signature](m *module[T], err error) *GuestError {
+	guestErr := &GuestError{
+		Function: m.template.identifier,
+		Kind:     TrapKindNone,
+		Err:      err,
+	}
+	if m.function != nil && m.function.instance != nil {
+		guestErr.InstanceID = hex.EncodeToString(m.function.instance.identifier)
+	}
+	if err == nil {
+		return guestErr
+	}
+
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		guestErr.Kind = TrapKindExit
+		guestErr.ExitCode = exitErr.ExitCode()
+		return guestErr
+	}
+
+	message := err.Error()
+	if index := strings.Index(message, wasmStackTraceHeader); index >= 0 {
+		for _, frame := range strings.Split(message[index+len(wasmStackTraceHeader):], "\n") {
+			frame = strings.TrimSpace(frame)
+			if frame == "" {
+				// Anything after an empty line is not part of the wasm stack trace
+				break
+			}
+			guestErr.Stack = append(guestErr.Stack, frame)
+		}
+	}
+
+	guestErr.Kind = TrapKindUnknown
+	for trapErr, kind := range trapKinds() {
+		if errors.Is(err, trapErr) {
+			guestErr.Kind = kind
+			break
+		}
+	}
+
+	return guestErr
+}
+
+// trapKinds returns the errors that the wasm runtime wraps when a function traps, and their TrapKinds
+func trapKinds() map[error]TrapKind {
+	trapErrorsOnce.Do(func() {
+		trapErrors = make(map[error]TrapKind)
+
+		ctx := context.Background()
+		runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfigInterpreter())
+		defer runtime.Close(ctx)
+
+		probe, err := runtime.Instantiate(ctx, trapProbe)
+		if err != nil {
+			return
+		}
+
+		for _, kind := range []TrapKind{TrapKindUnreachable, TrapKindOutOfBounds, TrapKindStackOverflow} {
+			_, err = probe.ExportedFunction(string(kind)).Call(ctx)
+			for unwrapped := errors.Unwrap(err); unwrapped != nil; unwrapped = errors.Unwrap(err) {
+				err = unwrapped
+			}
+			if err != nil {
+				trapErrors[err] = kind
+			}
+		}
+	})
+	return trapErrors
+}
+
+// wrapRunError adds the identifier of the function that failed to the given error,
+// unless it was caused by a GuestError, which already identifies the function
+func wrapRunError(identifier string, err error) error {
+	var guestErr *GuestError
+	if errors.As(err, &guestErr) {
+		return err
+	}
+	return fmt.Errorf("failed to run function '%s': %w", identifier, err)
+}
+
+func (e *GuestError) Error() string {
+	var b strings.Builder
+	switch e.Kind {
+	case TrapKindNone:
+		fmt.Fprintf(&b, "failed to run function '%s'", e.Function)
+	case TrapKindExit:
+		fmt.Fprintf(&b, "function '%s' exited with code %d", e.Function, e.ExitCode)
+	case TrapKindUnknown:
+		fmt.Fprintf(&b, "function '%s' trapped: %v", e.Function, e.Err)
+		return b.String()
+	default:
+		fmt.Fprintf(&b, "function '%s' trapped: %s", e.Function, e.Kind)
+	}
+	if len(e.Stack) > 0 {
+		b.WriteString(wasmStackTraceHeader)
+		b.WriteString("\t")
+		b.WriteString(strings.Join(e.Stack, "\n\t"))
+	}
+	return b.String()
+}
+
+func (e *GuestError) Unwrap() error {
+	return e.Err
+}
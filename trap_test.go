@@ -0,0 +1,102 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withFunctionNames returns a copy of the given wasm binary with a name
+// section containing the given function names appended to it
+func withFunctionNames(wasm []byte, names []string) []byte {
+	appendName := func(b []byte, name string) []byte {
+		b = binary.AppendUvarint(b, uint64(len(name)))
+		return append(b, name...)
+	}
+
+	var functionNames []byte
+	functionNames = binary.AppendUvarint(functionNames, uint64(len(names)))
+	for i, name := range names {
+		functionNames = binary.AppendUvarint(functionNames, uint64(i))
+		functionNames = appendName(functionNames, name)
+	}
+
+	section := appendName(nil, "name")
+	section = append(section, 1)
+	section = binary.AppendUvarint(section, uint64(len(functionNames)))
+	section = append(section, functionNames...)
+
+	named := append([]byte{}, wasm...)
+	named = append(named, 0)
+	named = binary.AppendUvarint(named, uint64(len(section)))
+	return append(named, section...)
+}
+
+func TestGuestError(t *testing.T) {
+	guest := testGuest(t, "first", false)
+	guest.Function = withFunctionNames(guest.Function, []string{"next", "proc_exit", "fd_write", "resize", "initialize", "recurse", "output", "run"})
+
+	r := newTestScale(t, NewConfig(newTestSignature).WithFunction(guest))
+	instance, err := r.Instance()
+	require.NoError(t, err)
+
+	tests := []struct {
+		input    string
+		kind     TrapKind
+		exitCode uint32
+		stack    []string
+	}{
+		{input: "t", kind: TrapKindUnreachable, stack: []string{".run() i64"}},
+		{input: "o", kind: TrapKindOutOfBounds, stack: []string{".run() i64"}},
+		{input: "s", kind: TrapKindStackOverflow},
+		{input: "x", kind: TrapKindExit, exitCode: 3},
+		{input: "z", kind: TrapKindNone},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.kind), func(t *testing.T) {
+			_, err := runTestInstance(t, instance, test.input)
+			require.Error(t, err)
+
+			var guestErr *GuestError
+			require.True(t, errors.As(err, &guestErr))
+			assert.Equal(t, "first:latest", guestErr.Function)
+			assert.Equal(t, hex.EncodeToString(instance.identifier), guestErr.InstanceID)
+			assert.Equal(t, test.kind, guestErr.Kind)
+			assert.Equal(t, test.exitCode, guestErr.ExitCode)
+			assert.Equal(t, test.stack, guestErr.Stack)
+			assert.Equal(t, 1, strings.Count(err.Error(), "first:latest"))
+		})
+	}
+}
+
+func TestTrapKinds(t *testing.T) {
+	kinds := make([]TrapKind, 0, len(trapKinds()))
+	for _, kind := range trapKinds() {
+		kinds = append(kinds, kind)
+	}
+	assert.ElementsMatch(t, []TrapKind{TrapKindUnreachable, TrapKindOutOfBounds, TrapKindStackOverflow}, kinds)
+}
@@ -0,0 +1,174 @@
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"sync"
+
+	interfaces "github.com/loopholelabs/scale-signature-interfaces"
+)
+
+// instancePool keeps a number of fully initialized instances ready to be acquired,
+// and refills itself in the background whenever instances are acquired
+type instancePool[T interfaces.Signature] struct {
+	runtime *Scale[T]
+
+	// idle contains the ready instances, and its capacity is the maximum size of the pool
+	idle chan *Instance[T]
+
+	refill    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// newInstancePool creates a new instance pool with the given size, and fills it before returning
+func newInstancePool[T interfaces.Signature](runtime *Scale[T], size uint32) (*instancePool[T], error) {
+	p := &instancePool[T]{
+		runtime: runtime,
+		idle:    make(chan *Instance[T], size),
+		refill:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	err := p.fill()
+	if err != nil {
+		return nil, err
+	}
+
+	p.wg.Add(1)
+	go p.run()
+
+	return p, nil
+}
+
+// Get returns a ready instance from the pool, or creates a new one if the pool is empty
+//
+// Instances that were created from a previous version of the function chain are discarded.
+func (p *instancePool[T]) Get(ctx context.Context) (*Instance[T], error) {
+	defer p.signal()
+	for {
+		select {
+		case instance := <-p.idle:
+			if p.stale(instance) {
+				instance.Close()
+				continue
+			}
+			return instance, nil
+		default:
+			err := p.runtime.acquire()
+			if err != nil {
+				return nil, err
+			}
+			defer p.runtime.release()
+			return newInstance(ctx, p.runtime)
+		}
+	}
+}
+
+// Put returns an instance to the pool, unless the pool is full, closed,
+// or the instance was created from a previous version of the function chain,
+// in which case the instance is closed instead
+func (p *instancePool[T]) Put(instance *Instance[T]) {
+	if p.stale(instance) {
+		instance.Close()
+		return
+	}
+
+	select {
+	case <-p.done:
+		instance.Close()
+		return
+	default:
+	}
+
+	select {
+	case p.idle <- instance:
+	default:
+		instance.Close()
+	}
+}
+
+// Close stops refilling the pool and closes its instances
+func (p *instancePool[T]) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+		for {
+			select {
+			case instance := <-p.idle:
+				instance.Close()
+			default:
+				return
+			}
+		}
+	})
+}
+
+// stale returns true if the instance was created from a previous version of the function chain
+func (p *instancePool[T]) stale(instance *Instance[T]) bool {
+	_, generation := p.runtime.chain()
+	return instance.generation != generation
+}
+
+// signal asks the background goroutine to refill the pool
+func (p *instancePool[T]) signal() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// run refills the pool whenever it is signalled, until the pool is closed
+func (p *instancePool[T]) run() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-p.refill:
+			// Errors are not reported here, since the same error will be
+			// returned when Get has to create an instance itself
+			_ = p.fill()
+		}
+	}
+}
+
+// fill creates new instances until the pool is full
+func (p *instancePool[T]) fill() error {
+	for len(p.idle) < cap(p.idle) {
+		select {
+		case <-p.done:
+			return nil
+		default:
+		}
+
+		instance, err := p.runtime.Instance()
+		if err != nil {
+			return err
+		}
+
+		select {
+		case p.idle <- instance:
+		default:
+			instance.Close()
+			return nil
+		}
+	}
+	return nil
+}
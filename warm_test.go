@@ -0,0 +1,119 @@
+//go:build !integration && !generate
+
+/*
+	Copyright 2023 Loophole Labs
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+		   http://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package scale
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmInstances(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)).
+		WithWarmInstances(2))
+	require.Len(t, r.instances.idle, 2)
+
+	ready := func() bool {
+		return len(r.instances.idle) == 2
+	}
+
+	instance, err := r.AcquireInstance(context.Background(), func(sig *testSignature) (*testSignature, error) {
+		sig.data = append(sig.data, '!')
+		return sig, nil
+	})
+	require.NoError(t, err)
+	assert.Eventually(t, ready, time.Second, time.Millisecond)
+
+	output, err := runTestInstance(t, instance, "n")
+	require.NoError(t, err)
+	assert.Equal(t, "n!", output)
+
+	r.ReleaseInstance(instance)
+	assert.Len(t, r.instances.idle, 2)
+
+	err = r.ReplaceFunction("first", testGuest(t, "second", false))
+	require.NoError(t, err)
+
+	instance, err = r.AcquireInstance(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "second:latest", instance.head.template.identifier)
+
+	output, err = runTestInstance(t, instance, "n")
+	require.NoError(t, err)
+	assert.Equal(t, "n", output)
+
+	assert.Eventually(t, ready, time.Second, time.Millisecond)
+	for len(r.instances.idle) > 0 {
+		warm := <-r.instances.idle
+		assert.Equal(t, "second:latest", warm.head.template.identifier)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = r.AcquireInstance(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, r.Close(context.Background()))
+	_, err = r.AcquireInstance(context.Background())
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestWarmInstancesClosed(t *testing.T) {
+	r := newTestScale(t, NewConfig(newTestSignature).
+		WithFunction(testGuest(t, "first", false)).
+		WithWarmInstances(1))
+
+	instance, err := r.AcquireInstance(context.Background())
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return len(r.instances.idle) == 1
+	}, time.Second, time.Millisecond)
+
+	// The pool is full, so the released instance is closed
+	extra, err := r.Instance()
+	require.NoError(t, err)
+	r.ReleaseInstance(extra)
+	assert.Nil(t, extra.head.module)
+
+	// Stale instances are closed when they are released or acquired, which closes the replaced function
+	err = r.ReplaceFunction("first", testGuest(t, "second", false))
+	require.NoError(t, err)
+	r.ReleaseInstance(instance)
+	assert.Nil(t, instance.head.module)
+
+	acquired, err := r.AcquireInstance(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "second:latest", acquired.head.template.identifier)
+
+	r.templatesMu.RLock()
+	assert.Empty(t, r.retired)
+	r.templatesMu.RUnlock()
+
+	r.activeModulesMu.RLock()
+	for _, m := range r.activeModules {
+		assert.Equal(t, "second:latest", m.template.identifier)
+	}
+	r.activeModulesMu.RUnlock()
+
+	require.NoError(t, r.Close(context.Background()))
+}